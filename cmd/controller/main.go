@@ -2,12 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
 
 	"github.com/waggle-sensor/plugin-controller/pkg/controller"
 )
 
+var Version = "0.0.0"
+
 func getenv(key string, def string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
@@ -23,10 +27,12 @@ func mustParseInt(s string) int {
 func main() {
 	var config controller.ControllerConfig
 	var configPath string
-	// config.Version = Version
+	config.Version = Version
 	// flag.BoolVar(&config.Debug, "debug", false, "flag to debug")
 	flag.StringVar(&configPath, "config", "", "path to config file")
 	flag.BoolVar(&config.EnableCPUPerformanceLogging, "enable-cpu-performance", false, "Enable CPU performance logging")
+	flag.BoolVar(&config.EnableThreadPerformanceLogging, "enable-thread-performance", false, "Enable per-thread CPU performance logging")
+	flag.IntVar(&config.ThreadMetricsMaxNames, "thread-metrics-max-names", 20, "Maximum number of thread names exported. The rest is grouped into \"other\"")
 	flag.BoolVar(&config.EnableGPUPerformanceLogging, "enable-gpu-performance", false, "Enable GPU performance logging")
 	flag.IntVar(&config.PerformanceCollectionInterval, "performance-collection-interval", 5, "Interval in seconds to collect performance metrics")
 	flag.BoolVar(&config.EnableMetricsPublishing, "enable-metrics-publishing", false, "Attempt to publish metrcis to RabbitMQ")
@@ -39,7 +45,91 @@ func main() {
 	flag.StringVar(&config.PluginProcessName, "plugin-process-name", "", "Process name of the plugin")
 	// flag.StringVar(&config.AppCgroupDir, "app-cgroup-dir", "data", "Path to meta directory")
 	flag.StringVar(&config.GPUMetricHost, "gpu-metric-host", getenv("GPU_METRIC_HOST", ""), "Host IP for Prometheus-formatted GPU metric")
+	flag.IntVar(&config.GPUMetricPort, "gpu-metric-port", 9101, "Port for Prometheus-formatted GPU metric")
+	flag.IntVar(&config.GPUMetricTimeout, "gpu-metric-timeout", 5, "Timeout in seconds to scrape GPU metric")
+	flag.BoolVar(&config.GPUMetricTLS, "gpu-metric-tls", false, "Scrape GPU metric over HTTPS")
+	flag.StringVar(&config.GPUMetricCACert, "gpu-metric-cacert", "", "Path to CA certificate to verify the GPU metric exporter")
+	flag.BoolVar(&config.GPUMetricInsecureSkipVerify, "gpu-metric-insecure-skip-verify", false, "Skip verifying the certificate of the GPU metric exporter")
+	flag.StringVar(&config.GPUMetricBearerToken, "gpu-metric-bearer-token", getenv("GPU_METRIC_BEARER_TOKEN", ""), "Bearer token to scrape GPU metric")
+	flag.BoolVar(&config.EnableResourceBudget, "enable-resource-budget", false, "Enforce resource budgets on the plugin")
+	flag.Float64Var(&config.BudgetCPUSeconds, "budget-cpu-seconds", 0, "CPU time budget of the plugin in seconds. 0 means no limit")
+	flag.Float64Var(&config.BudgetMemoryBytes, "budget-memory-bytes", 0, "Working set memory budget of the plugin in bytes. 0 means no limit")
+	flag.IntVar(&config.BudgetWallClockSeconds, "budget-wall-clock", 0, "Wall-clock time budget of the plugin in seconds. 0 means no limit")
+	flag.IntVar(&config.BudgetGracePeriod, "budget-grace-period", 30, "Seconds to wait before escalating to the next budget enforcement action")
+	flag.IntVar(&config.BudgetPauseDuration, "budget-pause-duration", 10, "Seconds to pause the plugin when it is over budget")
+	flag.StringVar(&config.APIAuthToken, "api-auth-token", getenv("PLUGIN_CONTROLLER_API_TOKEN", ""), "Bearer token to authorize plugin control API requests. The control API is disabled if empty")
+	flag.BoolVar(&config.PluginControlProcessTree, "plugin-control-process-tree", false, "Send control signals to the whole process tree of the plugin")
+	flag.BoolVar(&config.EnableRunSummary, "enable-run-summary", false, "Report a performance summary when the plugin finishes")
+	flag.StringVar(&config.RunSummaryPath, "run-summary-path", "", "Path to write the run summary in JSON")
+	flag.BoolVar(&config.EnablePerformanceWindows, "enable-performance-windows", false, "Keep 1m, 5m and 15m statistics of performance samples")
+	flag.BoolVar(&config.PublishWindowedStats, "publish-windowed-stats", false, "Publish 1m statistics of performance samples every minute instead of raw samples")
+	flag.BoolVar(&config.EnableEnergyEstimation, "enable-energy-estimation", false, "Estimate energy consumed by the plugin from Jetson power rails or RAPL")
+	flag.StringVar(&config.SysfsRoot, "sysfs-root", "/sys", "Path to the sysfs root")
+	flag.StringVar(&config.GPUBackend, "gpu-backend", "auto", "GPU metric backend: auto, exporter, jetson, nvidia or mock. auto detects the backend available on the node")
+	flag.StringVar(&config.NvidiaSMIPath, "nvidia-smi-path", "nvidia-smi", "Path to nvidia-smi for the nvidia GPU backend")
+	flag.IntVar(&config.EventHistorySize, "event-history-size", 1000, "Number of recent events kept for the events API. 0 disables the history")
+	flag.IntVar(&config.EventHistoryMaxAge, "event-history-max-age", 3600, "Seconds to keep events for the events API. 0 keeps them until the history is full")
+	flag.BoolVar(&config.EnableAggregatedPublishing, "enable-aggregated-publishing", false, "Publish count, min, mean, max and last of numeric samples as one <event type>.agg message per aggregation window. Raw samples are published only on the node")
+	flag.IntVar(&config.AggregationWindow, "aggregation-window", 60, "Window in seconds to aggregate samples for aggregated publishing")
+	flag.BoolVar(&config.EnableRemoteControl, "enable-remote-control", false, "Receive control messages over RabbitMQ")
+	flag.StringVar(&config.ControlExchange, "control-exchange", "plugin-control", "RabbitMQ exchange to receive control messages from")
+	flag.StringVar(&config.ControlQueue, "control-queue", "", "RabbitMQ queue to receive control messages. Default is plugin-controller.<control topic>")
+	flag.StringVar(&config.ControlTopic, "control-topic", "", "Routing key of control messages for this plugin. Default is the RabbitMQ app ID")
+	flag.BoolVar(&config.EnableResourceProfile, "enable-resource-profile", false, "Learn the resource profile of the plugin and publish it for the scheduler")
+	flag.IntVar(&config.ProfileWarmup, "profile-warmup", 60, "Seconds since the plugin starts that are taken as its startup transient")
+	flag.IntVar(&config.ProfileInterval, "profile-interval", 300, "Interval in seconds to publish the resource profile")
+	flag.Var(&config.PublishingRoutes, "publishing-routes", "Comma separated routes of event types to publishing scopes as <event type>=<scope>[:<rate per minute>]. A trailing * matches a prefix and the scope none drops events. Unrouted events use the metrics publishing scope")
+	flag.StringVar(&config.PodInfoDir, "pod-info-dir", "/etc/podinfo", "Path to the Kubernetes downward API volume with the pod's name, namespace and labels")
+	flag.StringVar(&config.StoreDir, "store-dir", "", "Directory to store performance samples on disk. The store is disabled if empty")
+	flag.IntVar(&config.StoreRetentionDays, "store-retention-days", 30, "Days to keep stored samples. 0 keeps them forever")
+	flag.IntVar(&config.StoreDownsampleAfterDays, "store-downsample-after-days", 1, "Days after which stored samples are downsampled. 0 disables downsampling")
+	flag.IntVar(&config.StoreDownsampleStep, "store-downsample-step", 60, "Seconds to average stored samples over when downsampling")
+	flag.BoolVar(&config.DaemonMode, "daemon", false, "Monitor every plugin container on the node from the host's kubepods cgroup hierarchy instead of a single plugin")
+	flag.StringVar(&config.HostCgroupRoot, "host-cgroup-root", "/sys/fs/cgroup", "Path to the host's cgroup root in daemon mode")
+	flag.StringVar(&config.ProcfsRoot, "procfs-root", "/proc", "Path to the procfs root to find the plugin's process and cgroup")
+	flag.StringVar(&config.HostProcfsRoot, "host-procfs-root", "", "Path to the host's procfs to map PIDs of the plugin to the host's PIDs that nvidia-smi reports. Not needed if the controller runs in the host's PID namespace")
+	flag.BoolVar(&config.EnablePublishingProxy, "enable-publishing-proxy", false, "Accept measurements from applications over HTTP and UDP and publish them to Waggle")
+	flag.StringVar(&config.PublishingProxyAddress, "publishing-proxy-address", "127.0.0.1:9102", "Address of the HTTP endpoint of the publishing proxy")
+	flag.StringVar(&config.PublishingProxyUDPAddress, "publishing-proxy-udp-address", "", "Address of the UDP endpoint of the publishing proxy. UDP is disabled if empty")
+	flag.StringVar(&config.OutputDir, "output-dir", "", "Directory to watch for output files of the plugin. Each new file is published as a Waggle upload. The watcher is disabled if empty")
+	flag.IntVar(&config.OutputDebounce, "output-debounce", 2000, "Milliseconds an output file must stay unchanged before it is uploaded")
+	flag.IntVar(&config.OutputUploadRetries, "output-upload-retries", 5, "Number of retries of a failed upload")
+	flag.StringVar(&config.UploadBackend, "upload-backend", controller.UploadBackendWaggle, "Uploader of output files: waggle or local. local only publishes upload messages and is for running outside of Waggle nodes")
+	flag.StringVar(&config.UploadDir, "upload-dir", "/run/waggle/uploads", "Waggle upload directory of the plugin")
+	flag.StringVar(&config.PluginStdoutPath, "plugin-stdout-path", "", "Path to a file that the plugin's stdout is written to. Lines are matched against LogRules of the config file")
+	flag.StringVar(&config.PluginStderrPath, "plugin-stderr-path", "", "Path to a file that the plugin's stderr is written to. Lines are matched against LogRules of the config file")
+	flag.StringVar(&config.LogLevel, "log-level", getenv("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	flag.StringVar(&config.LogFormat, "log-format", getenv("LOG_FORMAT", controller.LogFormatText), "Log format: text or json")
 	flag.Parse()
+	if configPath != "" {
+		// flags given on the command line take precedence over the config file
+		given := map[string]string{}
+		flag.Visit(func(f *flag.Flag) {
+			given[f.Name] = f.Value.String()
+		})
+		if err := controller.LoadConfigFile(configPath, &config); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		for name, value := range given {
+			flag.Set(name, value)
+		}
+	}
+	// arguments after -- are the plugin command that the controller runs as its child
+	if flag.NArg() > 0 {
+		config.PluginCommand = flag.Args()
+	}
+	logger, err := controller.NewLogger(os.Stdout, config.LogFormat, config.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
 	c := controller.NewController(config)
-	c.Run()
+	if config.DaemonMode {
+		c.RunDaemon()
+	} else {
+		c.Run()
+		os.Exit(c.ExitCode())
+	}
 }
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+// localPublishingScope is the scope of messages that stay on the node
+const localPublishingScope = "node"
+
+// aggregatedEventSuffix is appended to the event type of an aggregate
+const aggregatedEventSuffix = ".agg"
+
+// aggregateSourceEntry is the entry of an aggregate with the event type of its samples
+const aggregateSourceEntry = "aggregate_source"
+
+// Aggregate is the statistics of samples of an event type over a window
+type Aggregate struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+	Last  float64 `json:"last"`
+
+	sum float64
+}
+
+func (a *Aggregate) add(v float64) {
+	if a.Count == 0 || v < a.Min {
+		a.Min = v
+	}
+	if a.Count == 0 || v > a.Max {
+		a.Max = v
+	}
+	a.Count += 1
+	a.sum += v
+	a.Mean = a.sum / float64(a.Count)
+	a.Last = v
+}
+
+// PublishAggregator aggregates numeric events over a window so that one message per
+// event type is published per window instead of every sample. The aggregate of
+// an event type is notified as <event type>.agg with the statistics in a JSON value
+// and the window in its meta. The aggregate is routed by the event type of its samples
+type PublishAggregator struct {
+	Window   time.Duration
+	Notifier *interfacing.Notifier
+
+	mu         sync.Mutex
+	aggregates map[datatype.EventType]*Aggregate
+	quit       chan struct{}
+	log        *slog.Logger
+}
+
+func NewPublishAggregator(c ControllerConfig) *PublishAggregator {
+	window := c.AggregationWindow
+	if window < 1 {
+		window = 1
+	}
+	return &PublishAggregator{
+		Window:     time.Duration(window) * time.Second,
+		Notifier:   interfacing.NewNotifier(),
+		aggregates: map[datatype.EventType]*Aggregate{},
+		quit:       make(chan struct{}),
+		log:        componentLogger("aggregator"),
+	}
+}
+
+// Observe takes the event into its aggregate. It returns false if the event
+// does not have a numeric value and is not aggregated
+func (a *PublishAggregator) Observe(e datatype.Event) bool {
+	v, ok := eventValue(e)
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	agg, found := a.aggregates[e.Type]
+	if !found {
+		agg = &Aggregate{}
+		a.aggregates[e.Type] = agg
+	}
+	agg.add(v)
+	return true
+}
+
+// Flush returns events of the aggregates since the last flush and starts new aggregates
+func (a *PublishAggregator) Flush() []datatype.Event {
+	a.mu.Lock()
+	aggregates := a.aggregates
+	a.aggregates = map[datatype.EventType]*Aggregate{}
+	a.mu.Unlock()
+
+	eventTypes := make([]string, 0, len(aggregates))
+	for eventType := range aggregates {
+		eventTypes = append(eventTypes, string(eventType))
+	}
+	sort.Strings(eventTypes)
+	var events []datatype.Event
+	for _, eventType := range eventTypes {
+		blob, err := json.Marshal(aggregates[datatype.EventType(eventType)])
+		if err != nil {
+			a.log.Error("failed to encode aggregate", "event_type", eventType, "error", err)
+			continue
+		}
+		events = append(events, datatype.NewEventBuilder(datatype.EventType(eventType+aggregatedEventSuffix)).
+			AddValue(string(blob)).
+			AddEntry(measurementMetaKey, map[string]string{"window": a.Window.String()}).
+			AddEntry(aggregateSourceEntry, eventType).
+			Build())
+	}
+	return events
+}
+
+func (a *PublishAggregator) Stop() {
+	a.quit <- struct{}{}
+}
+
+// Run notifies the aggregates every window
+func (a *PublishAggregator) Run() {
+	ticker := time.NewTicker(a.Window)
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range a.Flush() {
+				a.Notifier.Notify(e)
+			}
+		case <-a.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestPublishAggregator(t *testing.T) {
+	a := NewPublishAggregator(ControllerConfig{AggregationWindow: 60})
+	for _, v := range []float64{3, 1, 5, 2} {
+		assert.Assert(t, a.Observe(datatype.NewEventBuilder(datatype.EventPluginPerfCPU).AddValue(v).Build()))
+	}
+	assert.Assert(t, a.Observe(datatype.NewEventBuilder(datatype.EventPluginPerfMem).AddValue(100.).Build()))
+	assert.Assert(t, !a.Observe(datatype.NewEventBuilder(EventPluginBudgetWarn).AddValue("over budget").Build()))
+
+	events := a.Flush()
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Type, datatype.EventType("sys.plugin.perf.cpu.agg"))
+	assert.Equal(t, events[0].Meta[aggregateSourceEntry], "sys.plugin.perf.cpu")
+	var agg Aggregate
+	assert.NilError(t, json.Unmarshal([]byte(events[0].Meta["value"].(string)), &agg))
+	assert.Equal(t, agg, Aggregate{Count: 4, Min: 1, Mean: 2.75, Max: 5, Last: 2})
+	assert.Equal(t, events[1].Type, datatype.EventType("sys.plugin.perf.mem.agg"))
+
+	// aggregates are not aggregated again
+	assert.Assert(t, !a.Observe(events[0]))
+	// aggregates start over after a flush
+	assert.Equal(t, len(a.Flush()), 0)
+
+	// the aggregate is one measurement with the window in its meta
+	msg := Metadata{"plugin_name": "test-pipeline"}.WaggleMessage(events[0])
+	assert.Equal(t, msg.Name, "sys.plugin.perf.cpu.agg")
+	assert.Equal(t, msg.Value, `{"count":4,"min":1,"mean":2.75,"max":5,"last":2}`)
+	assert.DeepEqual(t, msg.Meta, map[string]string{"plugin_name": "test-pipeline", "window": "1m0s"})
+}
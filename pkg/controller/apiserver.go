@@ -1,33 +1,77 @@
 package controller
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	EventPluginControlPause  datatype.EventType = "sys.plugin.control.pause"
+	EventPluginControlResume datatype.EventType = "sys.plugin.control.resume"
+	EventPluginControlSignal datatype.EventType = "sys.plugin.control.signal"
 )
 
 type APIServer struct {
-	version    string
-	port       int
-	mainRouter *mux.Router
+	version       string
+	port          int
+	mainRouter    *mux.Router
+	authToken     string
+	pluginControl *PluginControl
+	windows       *SampleWindows
+	history       *EventHistory
+	store         *SeriesStore
+	profiler      *ResourceProfiler
+	Notifier      *interfacing.Notifier
+	ready         chan struct{}
+	log           *slog.Logger
 }
 
-func NewAPIServer() *APIServer {
+func NewAPIServer(c ControllerConfig) *APIServer {
 	return &APIServer{
-		port: 9100,
+		version:   c.Version,
+		port:      9100,
+		authToken: c.APIAuthToken,
+		Notifier:  interfacing.NewNotifier(),
+		ready:     make(chan struct{}),
+		log:       componentLogger("api"),
 	}
 }
 
-func (api *APIServer) Run(prometheusGatherer *prometheus.Registry) {
-	api_address_port := fmt.Sprintf("0.0.0.0:%d", api.port)
-	log.Printf("API server starts at %q...", api_address_port)
+// Ready serves the API of the plugin. The components of the server must be set before.
+// Until then, the server only serves metrics
+func (api *APIServer) Ready() {
+	close(api.ready)
+}
+
+// whenReady responds 503 until the server is ready
+func (api *APIServer) whenReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-api.ready:
+			next.ServeHTTP(w, r)
+		default:
+			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "the plugin is not found yet"})
+		}
+	})
+}
+
+// routes returns the handler of the API
+func (api *APIServer) routes(prometheusGatherer *prometheus.Registry) http.Handler {
 	api.mainRouter = mux.NewRouter()
 	r := api.mainRouter
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -39,9 +83,292 @@ func (api *APIServer) Run(prometheusGatherer *prometheus.Registry) {
 			promhttp.HandlerFor(prometheusGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})).
 			Methods(http.MethodGet)
 	}
-	// api_route := r.PathPrefix("/api/v1").Subrouter()
-	// api_route.Handle("/kb/rules", http.HandlerFunc(api.handlerRules)).Methods(http.MethodGet, http.MethodPost)
-	log.Fatalln(http.ListenAndServe(api_address_port, handlers.LoggingHandler(os.Stdout, r)))
+	api_route := r.PathPrefix("/api/v1").Subrouter()
+	api_route.Use(api.whenReady)
+	api_route.Handle("/status", http.HandlerFunc(api.handlerStatus)).Methods(http.MethodGet)
+	api_route.Handle("/events", http.HandlerFunc(api.handlerEvents)).Methods(http.MethodGet)
+	api_route.Handle("/profile", http.HandlerFunc(api.handlerProfile)).Methods(http.MethodGet)
+	api_route.Handle("/series", http.HandlerFunc(api.handlerSeries)).Methods(http.MethodGet)
+	api_route.Handle("/query_range", http.HandlerFunc(api.handlerQueryRange)).Methods(http.MethodGet)
+	api_route.Handle("/export", http.HandlerFunc(api.handlerExport)).Methods(http.MethodGet)
+	api_route.Handle("/plugin/pause", api.authorize(http.HandlerFunc(api.handlerPluginPause))).Methods(http.MethodPost)
+	api_route.Handle("/plugin/resume", api.authorize(http.HandlerFunc(api.handlerPluginResume))).Methods(http.MethodPost)
+	api_route.Handle("/plugin/signal", api.authorize(http.HandlerFunc(api.handlerPluginSignal))).Methods(http.MethodPost)
+	return accessLog(api.log, r)
+}
+
+// Run serves the API. It starts before the plugin is found, so that metrics of the
+// controller can be scraped while it looks for the plugin
+func (api *APIServer) Run(prometheusGatherer *prometheus.Registry) {
+	api_address_port := fmt.Sprintf("0.0.0.0:%d", api.port)
+	api.log.Info("API server starts", "address", api_address_port)
+	if err := http.ListenAndServe(api_address_port, api.routes(prometheusGatherer)); err != nil {
+		api.log.Error("API server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// authorize allows requests that carry the configured API token as a bearer token.
+// Requests are denied when no API token is configured. Denied requests are audited
+// as failed actions of the route
+func (api *APIServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := path.Base(r.URL.Path)
+		if api.authToken == "" {
+			err := fmt.Errorf("no API token is configured")
+			api.audit(r, action, nil, "", err)
+			respondJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(api.authToken)) != 1 {
+			err := fmt.Errorf("invalid API token")
+			api.audit(r, action, nil, "", err)
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audit notifies an event for the control action taken on the plugin
+func (api *APIServer) audit(r *http.Request, action string, pids []int32, signal string, err error) {
+	eventType := EventPluginControlSignal
+	switch action {
+	case "pause":
+		eventType = EventPluginControlPause
+	case "resume":
+		eventType = EventPluginControlResume
+	}
+	b := datatype.NewEventBuilder(eventType).
+		AddEntry("action", action).
+		AddEntry("remote_addr", r.RemoteAddr).
+		AddEntry("pids", pids)
+	if signal != "" {
+		b.AddEntry("signal", signal)
+	}
+	if err != nil {
+		b.AddEntry("result", "failed").AddReason(err.Error())
+	} else {
+		b.AddEntry("result", "success")
+	}
+	api.Notifier.Notify(b.Build())
+}
+
+func (api *APIServer) controlPlugin(w http.ResponseWriter, r *http.Request, action string, sig syscall.Signal) {
+	if api.pluginControl == nil {
+		err := fmt.Errorf("plugin process is not found")
+		api.audit(r, action, nil, sig.String(), err)
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	pids, err := api.pluginControl.Signal(sig)
+	api.audit(r, action, pids, sig.String(), err)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"action": action,
+			"pids":   pids,
+			"error":  err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"action": action,
+		"pids":   pids,
+	})
+}
+
+func (api *APIServer) handlerStatus(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{}
+	if api.pluginControl != nil {
+		status["plugin_pid"] = api.pluginControl.proc.Pid
+	}
+	if api.windows != nil {
+		status["windows"] = api.windows.Stats(time.Now())
+	}
+	respondJSON(w, http.StatusOK, status)
+}
+
+// parseSince parses the since parameter either as a RFC3339 time or as
+// a duration back from now, e.g. 10m
+func parseSince(now time.Time, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be a RFC3339 time or a duration: %q", s)
+	}
+	return now.Add(-d), nil
+}
+
+// handlerEvents serves the recent events. It takes since, type and limit
+// parameters. type can be given multiple times or as a comma separated list
+func (api *APIServer) handlerEvents(w http.ResponseWriter, r *http.Request) {
+	if api.history == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "event history is not enabled"})
+		return
+	}
+	now := time.Now()
+	q := r.URL.Query()
+	since, err := parseSince(now, q.Get("since"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	types := map[datatype.EventType]bool{}
+	for _, t := range q["type"] {
+		for _, eventType := range strings.Split(t, ",") {
+			if eventType != "" {
+				types[datatype.EventType(eventType)] = true
+			}
+		}
+	}
+	limit := 100
+	if l := q.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid limit %q", l)})
+			return
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"events": api.history.Query(now, since, types, limit),
+	})
+}
+
+func (api *APIServer) handlerProfile(w http.ResponseWriter, r *http.Request) {
+	if api.profiler == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "resource profiling is not enabled"})
+		return
+	}
+	respondJSON(w, http.StatusOK, api.profiler.Profile())
+}
+
+// parseRange parses the series, start, end and step parameters of a range query.
+// start and end are RFC3339 times or durations back from now. The range is
+// the last hour by default
+func parseRange(r *http.Request) (string, time.Time, time.Time, time.Duration, error) {
+	now := time.Now()
+	q := r.URL.Query()
+	series := q.Get("series")
+	if series == "" {
+		return "", time.Time{}, time.Time{}, 0, fmt.Errorf("series is required")
+	}
+	start, end := now.Add(-time.Hour), now
+	var err error
+	if q.Get("start") != "" {
+		if start, err = parseSince(now, q.Get("start")); err != nil {
+			return "", time.Time{}, time.Time{}, 0, err
+		}
+	}
+	if q.Get("end") != "" {
+		if end, err = parseSince(now, q.Get("end")); err != nil {
+			return "", time.Time{}, time.Time{}, 0, err
+		}
+	}
+	var step time.Duration
+	if q.Get("step") != "" {
+		if step, err = time.ParseDuration(q.Get("step")); err != nil || step < 0 {
+			return "", time.Time{}, time.Time{}, 0, fmt.Errorf("invalid step %q", q.Get("step"))
+		}
+	}
+	return series, start, end, step, nil
+}
+
+func (api *APIServer) handlerSeries(w http.ResponseWriter, r *http.Request) {
+	if api.store == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "series store is not enabled"})
+		return
+	}
+	series, err := api.store.Series()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"series": series})
+}
+
+// handlerQueryRange serves points of a series. It takes series, start, end and step parameters
+func (api *APIServer) handlerQueryRange(w http.ResponseWriter, r *http.Request) {
+	if api.store == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "series store is not enabled"})
+		return
+	}
+	series, start, end, step, err := parseRange(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	points, err := api.store.Query(series, start, end, step)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"series": series,
+		"points": points,
+	})
+}
+
+// handlerExport serves points of a series as CSV or JSON lines depending on
+// the format parameter. It takes the same parameters as handlerQueryRange
+func (api *APIServer) handlerExport(w http.ResponseWriter, r *http.Request) {
+	if api.store == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "series store is not enabled"})
+		return
+	}
+	series, start, end, step, err := parseRange(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "jsonl" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown format %q", format)})
+		return
+	}
+	points, err := api.store.Query(series, start, end, step)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		WriteJSONLines(w, series, points)
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		WriteCSV(w, series, points)
+	}
+}
+
+func (api *APIServer) handlerPluginPause(w http.ResponseWriter, r *http.Request) {
+	api.controlPlugin(w, r, "pause", syscall.SIGSTOP)
+}
+
+func (api *APIServer) handlerPluginResume(w http.ResponseWriter, r *http.Request) {
+	api.controlPlugin(w, r, "resume", syscall.SIGCONT)
+}
+
+func (api *APIServer) handlerPluginSignal(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Signal string `json:"signal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		err = fmt.Errorf("failed to parse request: %s", err.Error())
+		api.audit(r, "signal", nil, "", err)
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	sig, err := parseSignal(body.Signal)
+	if err != nil {
+		api.audit(r, "signal", nil, body.Signal, err)
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	api.controlPlugin(w, r, "signal", sig)
 }
 
 func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
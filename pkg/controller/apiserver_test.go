@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+// serveTestAPI returns the response of the API to the request
+func serveTestAPI(api *APIServer, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	api.routes(prometheus.NewRegistry()).ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIServerReady(t *testing.T) {
+	api := NewAPIServer(ControllerConfig{Version: "0.1.0"})
+	// metrics are served while looking for the plugin
+	w := serveTestAPI(api, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	w = serveTestAPI(api, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	api.Ready()
+	w = serveTestAPI(api, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+// newTestControlRequest returns a control request of the API with the authorization header
+func newTestControlRequest(action string, body string, authorization string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/plugin/"+action, strings.NewReader(body))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+func TestAPIServerAuthorize(t *testing.T) {
+	api := NewAPIServer(ControllerConfig{})
+	api.Ready()
+	audits := make(chan datatype.Event, 10)
+	api.Notifier.Subscribe(audits)
+	// control is denied without a configured token
+	w := serveTestAPI(api, newTestControlRequest("pause", "", "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	e := <-audits
+	assert.Equal(t, e.Type, EventPluginControlPause)
+	assert.Equal(t, e.Meta["result"], "failed")
+
+	api.authToken = "secret"
+	for _, authorization := range []string{"", "secret", "Bearer wrong", "Basic secret"} {
+		w = serveTestAPI(api, newTestControlRequest("resume", "", authorization))
+		assert.Equal(t, w.Code, http.StatusUnauthorized, authorization)
+		e = <-audits
+		assert.Equal(t, e.Type, EventPluginControlResume)
+		assert.Equal(t, e.Meta["result"], "failed")
+	}
+	// the plugin is not found
+	w = serveTestAPI(api, newTestControlRequest("resume", "", "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+}
+
+func TestAPIServerControl(t *testing.T) {
+	cmd, pids := startTestProcessTree(t)
+	api := NewAPIServer(ControllerConfig{APIAuthToken: "secret"})
+	api.pluginControl = NewPluginControl(ControllerConfig{PluginControlProcessTree: true}, &process.Process{Pid: pids[0]})
+	api.Ready()
+	audits := make(chan datatype.Event, 10)
+	api.Notifier.Subscribe(audits)
+
+	w := serveTestAPI(api, newTestControlRequest("pause", "", "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusOK)
+	var response struct {
+		Action string  `json:"action"`
+		PIDs   []int32 `json:"pids"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, response.Action, "pause")
+	assert.DeepEqual(t, response.PIDs, pids)
+	e := <-audits
+	assert.Equal(t, e.Type, EventPluginControlPause)
+	assert.Equal(t, e.Meta["result"], "success")
+	waitForProcessState(t, pids[1], "T")
+
+	w = serveTestAPI(api, newTestControlRequest("resume", "", "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusOK)
+	<-audits
+	waitForProcessState(t, pids[1], "S")
+
+	w = serveTestAPI(api, newTestControlRequest("signal", `{"signal": "SIGSEGV"}`, "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	e = <-audits
+	assert.Equal(t, e.Type, EventPluginControlSignal)
+	assert.Equal(t, e.Meta["result"], "failed")
+	w = serveTestAPI(api, newTestControlRequest("signal", `not json`, "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	e = <-audits
+	assert.Equal(t, e.Meta["result"], "failed")
+
+	w = serveTestAPI(api, newTestControlRequest("signal", `{"signal": "term"}`, "Bearer secret"))
+	assert.Equal(t, w.Code, http.StatusOK)
+	e = <-audits
+	assert.Equal(t, e.Meta["signal"], syscall.SIGTERM.String())
+	assert.ErrorContains(t, cmd.Wait(), "signal: terminated")
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	EventPluginBudgetWarn      datatype.EventType = "sys.plugin.budget.warn"
+	EventPluginBudgetPause     datatype.EventType = "sys.plugin.budget.pause"
+	EventPluginBudgetResume    datatype.EventType = "sys.plugin.budget.resume"
+	EventPluginBudgetTerminate datatype.EventType = "sys.plugin.budget.terminate"
+	EventPluginBudgetKill      datatype.EventType = "sys.plugin.budget.kill"
+	EventPluginBudgetRecovered datatype.EventType = "sys.plugin.budget.recovered"
+)
+
+type budgetAction int
+
+const (
+	budgetActionNone budgetAction = iota
+	budgetActionWarn
+	budgetActionPause
+	budgetActionTerminate
+	budgetActionKill
+)
+
+// pluginSignaler sends signals to the plugin. It is implemented by PluginControl
+type pluginSignaler interface {
+	Pause() ([]int32, error)
+	Resume() ([]int32, error)
+	Signal(sig syscall.Signal) ([]int32, error)
+}
+
+// ResourceBudget enforces CPU-seconds, memory and wall-clock budgets on the plugin.
+// When the plugin goes over any of the budgets it escalates one step every grace period:
+// it warns, pauses the plugin (SIGSTOP followed by SIGCONT after the pause duration),
+// sends SIGTERM and finally sends SIGKILL. A budget of 0 means no limit.
+// The signals reach the plugin's process tree if the plugin control is configured so
+type ResourceBudget struct {
+	CPUSeconds    float64
+	MemoryBytes   float64
+	WallClock     time.Duration
+	GracePeriod   time.Duration
+	PauseDuration time.Duration
+	Notifier      *interfacing.Notifier
+	pluginProc    *process.Process
+	control       pluginSignaler
+	cpu           *CPUPerformanceLogging
+	quit          chan struct{}
+	interval      int
+	lastAction    budgetAction
+	lastActionT   time.Time
+	log           *slog.Logger
+}
+
+func NewResourceBudget(c ControllerConfig, pluginProc *process.Process, control pluginSignaler) *ResourceBudget {
+	return &ResourceBudget{
+		CPUSeconds:    c.BudgetCPUSeconds,
+		MemoryBytes:   c.BudgetMemoryBytes,
+		WallClock:     time.Duration(c.BudgetWallClockSeconds) * time.Second,
+		GracePeriod:   time.Duration(c.BudgetGracePeriod) * time.Second,
+		PauseDuration: time.Duration(c.BudgetPauseDuration) * time.Second,
+		Notifier:      interfacing.NewNotifier(),
+		pluginProc:    pluginProc,
+		control:       control,
+		cpu:           NewCPUPerformanceLogging(c),
+		quit:          make(chan struct{}),
+		interval:      c.PerformanceCollectionInterval,
+		lastAction:    budgetActionNone,
+		log:           componentLogger("budget"),
+	}
+}
+
+// exceeded returns the reason why the plugin is over its budget. It returns false
+// if the plugin is within all of the budgets
+func (b *ResourceBudget) exceeded(cpuSeconds float64, memory float64, wallClock time.Duration) (string, bool) {
+	if b.CPUSeconds > 0 && cpuSeconds > b.CPUSeconds {
+		return fmt.Sprintf("cpu time %.2f seconds exceeds budget %.2f seconds", cpuSeconds, b.CPUSeconds), true
+	}
+	if b.MemoryBytes > 0 && memory > b.MemoryBytes {
+		return fmt.Sprintf("memory %.0f bytes exceeds budget %.0f bytes", memory, b.MemoryBytes), true
+	}
+	if b.WallClock > 0 && wallClock > b.WallClock {
+		return fmt.Sprintf("wall-clock time %s exceeds budget %s", wallClock.Round(time.Second), b.WallClock), true
+	}
+	return "", false
+}
+
+// measure returns the plugin's cumulative CPU seconds, current working set memory and
+// its wall-clock time. A measurement that fails to read is returned as 0
+func (b *ResourceBudget) measure() (float64, float64, time.Duration) {
+	cpuSeconds, err := b.cpu.Cgroup.CPUSeconds()
+	if err != nil {
+		b.log.Error("failed to read cpu seconds", "error", err)
+	}
+	memory, err := b.cpu.ReadMemory()
+	if err != nil {
+		b.log.Error("failed to read memory", "error", err)
+	}
+	var wallClock time.Duration
+	if createTime, err := b.pluginProc.CreateTime(); err != nil {
+		b.log.Error("failed to read plugin create time", "error", err)
+	} else {
+		wallClock = time.Since(time.UnixMilli(createTime))
+	}
+	return cpuSeconds, memory, wallClock
+}
+
+func (b *ResourceBudget) notify(eventType datatype.EventType, reason string) {
+	b.log.Warn(reason, "event_type", eventType)
+	e := datatype.NewEventBuilder(eventType).
+		AddValue(reason).
+		AddEntry("pid", b.pluginProc.Pid).
+		Build()
+	b.Notifier.Notify(e)
+}
+
+// escalate takes the next action on the plugin
+func (b *ResourceBudget) escalate(reason string, now time.Time) {
+	switch b.lastAction {
+	case budgetActionNone:
+		b.notify(EventPluginBudgetWarn, reason)
+		b.lastAction = budgetActionWarn
+	case budgetActionWarn:
+		if _, err := b.control.Pause(); err != nil {
+			b.log.Error("failed to pause plugin", "error", err)
+			return
+		}
+		b.notify(EventPluginBudgetPause, reason)
+		time.AfterFunc(b.PauseDuration, func() {
+			if _, err := b.control.Resume(); err != nil {
+				b.log.Error("failed to resume plugin", "error", err)
+				return
+			}
+			b.notify(EventPluginBudgetResume, fmt.Sprintf("resumed after %s", b.PauseDuration))
+		})
+		b.lastAction = budgetActionPause
+	case budgetActionPause:
+		// a stopped process does not handle SIGTERM until it continues
+		b.control.Resume()
+		if _, err := b.control.Signal(syscall.SIGTERM); err != nil {
+			b.log.Error("failed to terminate plugin", "error", err)
+			return
+		}
+		b.notify(EventPluginBudgetTerminate, reason)
+		b.lastAction = budgetActionTerminate
+	case budgetActionTerminate:
+		if _, err := b.control.Signal(syscall.SIGKILL); err != nil {
+			b.log.Error("failed to kill plugin", "error", err)
+			return
+		}
+		b.notify(EventPluginBudgetKill, reason)
+		b.lastAction = budgetActionKill
+	default:
+		return
+	}
+	b.lastActionT = now
+}
+
+func (b *ResourceBudget) check() {
+	cpuSeconds, memory, wallClock := b.measure()
+	b.update(cpuSeconds, memory, wallClock, time.Now())
+}
+
+// update escalates the action on the plugin while it is over its budget, one step
+// every grace period, and recovers when it is back within the budget
+func (b *ResourceBudget) update(cpuSeconds float64, memory float64, wallClock time.Duration, now time.Time) {
+	reason, over := b.exceeded(cpuSeconds, memory, wallClock)
+	if !over {
+		if b.lastAction != budgetActionNone && b.lastAction < budgetActionTerminate {
+			b.notify(EventPluginBudgetRecovered, "plugin is back within its budget")
+			b.lastAction = budgetActionNone
+		}
+		return
+	}
+	if b.lastAction == budgetActionNone || now.Sub(b.lastActionT) >= b.GracePeriod {
+		b.escalate(reason, now)
+	}
+}
+
+func (b *ResourceBudget) Stop() {
+	b.quit <- struct{}{}
+}
+
+func (b *ResourceBudget) Run() {
+	ticker := time.NewTicker(time.Duration(b.interval) * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			b.check()
+		case <-b.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+// testSignaler records signals to the plugin. Signals fail while failing is set
+type testSignaler struct {
+	mu      sync.Mutex
+	signals []syscall.Signal
+	failing bool
+}
+
+func (s *testSignaler) Signal(sig syscall.Signal) ([]int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return nil, fmt.Errorf("failed to send %s", sig)
+	}
+	s.signals = append(s.signals, sig)
+	return []int32{1234}, nil
+}
+
+func (s *testSignaler) Pause() ([]int32, error) {
+	return s.Signal(syscall.SIGSTOP)
+}
+
+func (s *testSignaler) Resume() ([]int32, error) {
+	return s.Signal(syscall.SIGCONT)
+}
+
+func (s *testSignaler) sent() []syscall.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]syscall.Signal{}, s.signals...)
+}
+
+// assertBudgetEvents asserts the events notified since the last call
+func assertBudgetEvents(t *testing.T, events chan datatype.Event, expected ...datatype.EventType) {
+	t.Helper()
+	var got []datatype.EventType
+	for {
+		select {
+		case e := <-events:
+			got = append(got, e.Type)
+			continue
+		default:
+		}
+		break
+	}
+	assert.DeepEqual(t, got, expected)
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	b := NewResourceBudget(ControllerConfig{
+		BudgetCPUSeconds:       60,
+		BudgetMemoryBytes:      1e9,
+		BudgetWallClockSeconds: 3600,
+	}, nil, nil)
+	_, over := b.exceeded(30, 5e8, 10*time.Minute)
+	assert.Assert(t, !over)
+
+	reason, over := b.exceeded(61, 5e8, 10*time.Minute)
+	assert.Assert(t, over)
+	t.Log(reason)
+
+	_, over = b.exceeded(30, 2e9, 10*time.Minute)
+	assert.Assert(t, over)
+
+	_, over = b.exceeded(30, 5e8, 2*time.Hour)
+	assert.Assert(t, over)
+
+	// a budget of 0 means no limit
+	unlimited := NewResourceBudget(ControllerConfig{}, nil, nil)
+	_, over = unlimited.exceeded(1e6, 1e12, 1000*time.Hour)
+	assert.Assert(t, !over)
+}
+
+func TestBudgetEscalation(t *testing.T) {
+	control := &testSignaler{}
+	b := NewResourceBudget(ControllerConfig{
+		BudgetCPUSeconds:    60,
+		BudgetGracePeriod:   10,
+		BudgetPauseDuration: 3600,
+	}, &process.Process{Pid: 1234}, control)
+	events := make(chan datatype.Event, 10)
+	b.Notifier.Subscribe(events)
+	now := time.Now()
+	b.update(61, 0, 0, now)
+	assertBudgetEvents(t, events, EventPluginBudgetWarn)
+	assert.Equal(t, len(control.sent()), 0)
+
+	// the next step waits for the grace period
+	b.update(62, 0, 0, now.Add(5*time.Second))
+	assertBudgetEvents(t, events)
+
+	now = now.Add(10 * time.Second)
+	b.update(63, 0, 0, now)
+	assertBudgetEvents(t, events, EventPluginBudgetPause)
+	assert.DeepEqual(t, control.sent(), []syscall.Signal{syscall.SIGSTOP})
+
+	// the paused plugin continues to handle SIGTERM
+	now = now.Add(10 * time.Second)
+	b.update(64, 0, 0, now)
+	assertBudgetEvents(t, events, EventPluginBudgetTerminate)
+	assert.DeepEqual(t, control.sent(), []syscall.Signal{syscall.SIGSTOP, syscall.SIGCONT, syscall.SIGTERM})
+
+	now = now.Add(10 * time.Second)
+	b.update(65, 0, 0, now)
+	assertBudgetEvents(t, events, EventPluginBudgetKill)
+	assert.DeepEqual(t, control.sent(), []syscall.Signal{syscall.SIGSTOP, syscall.SIGCONT, syscall.SIGTERM, syscall.SIGKILL})
+
+	// nothing follows the kill and a terminated plugin does not recover
+	b.update(66, 0, 0, now.Add(time.Minute))
+	b.update(30, 0, 0, now.Add(2*time.Minute))
+	assertBudgetEvents(t, events)
+	assert.Equal(t, len(control.sent()), 4)
+}
+
+func TestBudgetRecovery(t *testing.T) {
+	control := &testSignaler{}
+	b := NewResourceBudget(ControllerConfig{
+		BudgetCPUSeconds:    60,
+		BudgetGracePeriod:   10,
+		BudgetPauseDuration: 3600,
+	}, &process.Process{Pid: 1234}, control)
+	events := make(chan datatype.Event, 10)
+	b.Notifier.Subscribe(events)
+	now := time.Now()
+	b.update(61, 0, 0, now)
+	b.update(61, 0, 0, now.Add(10*time.Second))
+	assertBudgetEvents(t, events, EventPluginBudgetWarn, EventPluginBudgetPause)
+
+	b.update(30, 0, 0, now.Add(15*time.Second))
+	assertBudgetEvents(t, events, EventPluginBudgetRecovered)
+	b.update(30, 0, 0, now.Add(20*time.Second))
+	assertBudgetEvents(t, events)
+
+	// going over the budget again starts from a warning
+	b.update(61, 0, 0, now.Add(25*time.Second))
+	assertBudgetEvents(t, events, EventPluginBudgetWarn)
+	assert.DeepEqual(t, control.sent(), []syscall.Signal{syscall.SIGSTOP})
+}
+
+func TestBudgetFailedAction(t *testing.T) {
+	control := &testSignaler{}
+	b := NewResourceBudget(ControllerConfig{
+		BudgetCPUSeconds:    60,
+		BudgetGracePeriod:   10,
+		BudgetPauseDuration: 3600,
+	}, &process.Process{Pid: 1234}, control)
+	events := make(chan datatype.Event, 10)
+	b.Notifier.Subscribe(events)
+	now := time.Now()
+	b.update(61, 0, 0, now)
+	control.failing = true
+	b.update(61, 0, 0, now.Add(10*time.Second))
+	assertBudgetEvents(t, events, EventPluginBudgetWarn)
+
+	// the failed pause is taken again on the next check
+	control.failing = false
+	b.update(61, 0, 0, now.Add(11*time.Second))
+	assertBudgetEvents(t, events, EventPluginBudgetPause)
+}
+
+func TestBudgetPauseResume(t *testing.T) {
+	control := &testSignaler{}
+	b := NewResourceBudget(ControllerConfig{
+		BudgetCPUSeconds:  60,
+		BudgetGracePeriod: 10,
+	}, &process.Process{Pid: 1234}, control)
+	b.PauseDuration = 10 * time.Millisecond
+	events := make(chan datatype.Event, 10)
+	b.Notifier.Subscribe(events)
+	now := time.Now()
+	b.update(61, 0, 0, now)
+	b.update(61, 0, 0, now.Add(10*time.Second))
+	assert.Equal(t, (<-events).Type, EventPluginBudgetWarn)
+	assert.Equal(t, (<-events).Type, EventPluginBudgetPause)
+	select {
+	case e := <-events:
+		assert.Equal(t, e.Type, EventPluginBudgetResume)
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin is not resumed after the pause")
+	}
+	assert.DeepEqual(t, control.sent(), []syscall.Signal{syscall.SIGSTOP, syscall.SIGCONT})
+}
@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupV1 = 1
+	cgroupV2 = 2
+)
+
+// Cgroup reads resource usage of a cgroup. For cgroup v1, CPUDir, MemoryDir and IODir
+// are the cgroup's directories in the cpuacct, memory and blkio hierarchies. For cgroup v2,
+// all of them are the cgroup's directory in the unified hierarchy
+type Cgroup struct {
+	Version   int
+	CPUDir    string
+	MemoryDir string
+	IODir     string
+}
+
+// NewCgroupV1 returns the cgroup at rel of the v1 hierarchies mounted under root,
+// e.g. /sys/fs/cgroup
+func NewCgroupV1(root string, rel string) *Cgroup {
+	return &Cgroup{
+		Version:   cgroupV1,
+		CPUDir:    path.Join(root, "cpu,cpuacct", rel),
+		MemoryDir: path.Join(root, "memory", rel),
+		IODir:     path.Join(root, "blkio", rel),
+	}
+}
+
+// NewCgroupV2 returns the cgroup at dir of the unified hierarchy
+func NewCgroupV2(dir string) *Cgroup {
+	return &Cgroup{
+		Version:   cgroupV2,
+		CPUDir:    dir,
+		MemoryDir: dir,
+		IODir:     dir,
+	}
+}
+
+// isCgroupV2 tells if dir is in the unified hierarchy. Every cgroup of the unified
+// hierarchy has cgroup.controllers
+func isCgroupV2(dir string) bool {
+	_, err := os.Stat(path.Join(dir, "cgroup.controllers"))
+	return err == nil
+}
+
+// CgroupFromRoot returns the root cgroup of the hierarchies mounted at root
+func CgroupFromRoot(root string) *Cgroup {
+	if isCgroupV2(root) {
+		return NewCgroupV2(root)
+	}
+	return NewCgroupV1(root, "")
+}
+
+// cgroupMount is a cgroup filesystem in mountinfo. Root is the path of the cgroup
+// mounted at MountPoint
+type cgroupMount struct {
+	Root       string
+	MountPoint string
+	Version    int
+	Options    map[string]bool
+}
+
+// mountinfoUnescaper undoes the octal escapes of mountinfo
+var mountinfoUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// parseCgroupMounts returns the cgroup filesystems of a mountinfo file, e.g.
+//
+// 30 24 0:26 / /sys/fs/cgroup/memory rw,nosuid - cgroup cgroup rw,memory
+func parseCgroupMounts(mountinfo string) ([]cgroupMount, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []cgroupMount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields, super, found := strings.Cut(scanner.Text(), " - ")
+		mountFields, superFields := strings.Fields(fields), strings.Fields(super)
+		if !found || len(mountFields) < 5 || len(superFields) < 3 {
+			continue
+		}
+		m := cgroupMount{
+			Root:       mountinfoUnescaper.Replace(mountFields[3]),
+			MountPoint: mountinfoUnescaper.Replace(mountFields[4]),
+			Options:    map[string]bool{},
+		}
+		switch superFields[0] {
+		case "cgroup":
+			m.Version = cgroupV1
+		case "cgroup2":
+			m.Version = cgroupV2
+		default:
+			continue
+		}
+		for _, o := range strings.Split(superFields[2], ",") {
+			m.Options[o] = true
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// parseProcCgroup returns the cgroup path of the process per v1 controller. The path
+// of the unified hierarchy has the key ""
+func parseProcCgroup(procCgroup string) (map[string]string, error) {
+	buf, err := os.ReadFile(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		// each line is hierarchy-ID:controller-list:cgroup-path
+		sp := strings.SplitN(line, ":", 3)
+		if len(sp) != 3 {
+			continue
+		}
+		if sp[1] == "" {
+			paths[""] = sp[2]
+			continue
+		}
+		for _, controller := range strings.Split(sp[1], ",") {
+			paths[controller] = sp[2]
+		}
+	}
+	return paths, nil
+}
+
+// resolveCgroupDir finds the directory of the process's cgroup under the mount. The cgroup
+// path is relative to the cgroup namespace of the controller, while the mount may be
+// of the process's own cgroup namespace. The candidate whose cgroup.procs has the process
+// is taken, then any existing candidate
+func resolveCgroupDir(rootfs string, m cgroupMount, cgroupPath string, pid int32) (string, error) {
+	var candidates []string
+	if rel, found := strings.CutPrefix(cgroupPath, m.Root); found && !strings.HasPrefix(cgroupPath, "/..") {
+		candidates = append(candidates, path.Join(rootfs, m.MountPoint, rel))
+	}
+	// a mount within a private cgroup namespace has the process's cgroup at its root
+	candidates = append(candidates, path.Join(rootfs, m.MountPoint))
+	var existing []string
+	for _, dir := range candidates {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		existing = append(existing, dir)
+		pids, err := (&Cgroup{CPUDir: dir}).PIDs()
+		if err != nil {
+			continue
+		}
+		for _, p := range pids {
+			if p == pid {
+				return dir, nil
+			}
+		}
+	}
+	if len(existing) > 0 {
+		return existing[0], nil
+	}
+	return "", fmt.Errorf("cgroup %s is not found under %s", cgroupPath, path.Join(rootfs, m.MountPoint))
+}
+
+// ResolveCgroup finds the cgroup of the process from <procfs root>/<pid>/cgroup and the
+// cgroup mounts in <procfs root>/<pid>/mountinfo. The mounts are reached through the root
+// filesystem of the process. The v1 hierarchies are taken if cpuacct is mounted as v1,
+// which covers the hybrid layout
+func ResolveCgroup(procfsRoot string, pid int32) (*Cgroup, error) {
+	procDir := path.Join(procfsRoot, fmt.Sprint(pid))
+	paths, err := parseProcCgroup(path.Join(procDir, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := parseCgroupMounts(path.Join(procDir, "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	rootfs := path.Join(procDir, "root")
+	v1Mounts := map[string]cgroupMount{}
+	var v2Mount *cgroupMount
+	for i, m := range mounts {
+		switch m.Version {
+		case cgroupV1:
+			for _, controller := range []string{"cpuacct", "memory", "blkio"} {
+				if m.Options[controller] {
+					v1Mounts[controller] = m
+				}
+			}
+		case cgroupV2:
+			v2Mount = &mounts[i]
+		}
+	}
+	if _, found := v1Mounts["cpuacct"]; found {
+		g := &Cgroup{Version: cgroupV1}
+		for controller, dir := range map[string]*string{"cpuacct": &g.CPUDir, "memory": &g.MemoryDir, "blkio": &g.IODir} {
+			m, found := v1Mounts[controller]
+			cgroupPath, known := paths[controller]
+			if !found || !known {
+				continue
+			}
+			if *dir, err = resolveCgroupDir(rootfs, m, cgroupPath, pid); err != nil {
+				return nil, err
+			}
+		}
+		return g, nil
+	}
+	cgroupPath, known := paths[""]
+	if v2Mount == nil || !known {
+		return nil, fmt.Errorf("no cgroup mount is found for process %d", pid)
+	}
+	dir, err := resolveCgroupDir(rootfs, *v2Mount, cgroupPath, pid)
+	if err != nil {
+		return nil, err
+	}
+	return NewCgroupV2(dir), nil
+}
+
+// CPUSecondsPerCPU returns cumulative CPU time per core in seconds. Only cgroup v1
+// accounts CPU time per core
+func (g *Cgroup) CPUSecondsPerCPU() ([]float64, error) {
+	if g.Version != cgroupV1 {
+		return nil, fmt.Errorf("per-cpu usage is not available in cgroup v%d", g.Version)
+	}
+	buffer, err := os.ReadFile(path.Join(g.CPUDir, "cpuacct.usage_percpu"))
+	if err != nil {
+		return []float64{}, err
+	}
+	values := strings.Fields(string(buffer))
+	out := make([]float64, len(values))
+	for index, strNanoSeconds := range values {
+		nanoSeconds, err := strconv.ParseUint(strNanoSeconds, 10, 64)
+		if err != nil {
+			return out, err
+		}
+		out[index] = float64(nanoSeconds) / 1e9
+	}
+	return out, nil
+}
+
+// CPUSeconds returns cumulative CPU time in seconds
+func (g *Cgroup) CPUSeconds() (float64, error) {
+	if g.Version == cgroupV1 {
+		values, err := g.CPUSecondsPerCPU()
+		if err != nil {
+			return 0, err
+		}
+		total := 0.
+		for _, v := range values {
+			total += v
+		}
+		return total, nil
+	}
+	buffer, err := os.ReadFile(path.Join(g.CPUDir, "cpu.stat"))
+	if err != nil {
+		return 0, err
+	}
+	usage, err := getUintValueFromMatch(buffer, `(?m)^usage_usec [0-9]+`)
+	if err != nil {
+		return 0, err
+	}
+	return float64(usage) / 1e6, nil
+}
+
+// MemoryWorkingSet returns the working set memory in bytes. Working set memory is the
+// amount that cannot be evicted and calculated by total used memory - total inactive file
+func (g *Cgroup) MemoryWorkingSet() (float64, error) {
+	usageFile, inactivePattern := "memory.usage_in_bytes", `total_inactive_file [0-9]+`
+	if g.Version == cgroupV2 {
+		usageFile, inactivePattern = "memory.current", `(?m)^inactive_file [0-9]+`
+	}
+	usageBuffer, err := os.ReadFile(path.Join(g.MemoryDir, usageFile))
+	if err != nil {
+		return 0, err
+	}
+	usage, err := strconv.ParseUint(strings.TrimSpace(string(usageBuffer)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	statBuffer, err := os.ReadFile(path.Join(g.MemoryDir, "memory.stat"))
+	if err != nil {
+		return 0, err
+	}
+	inactive, err := getUintValueFromMatch(statBuffer, inactivePattern)
+	if err != nil {
+		return 0, err
+	}
+	if inactive > usage {
+		return 0, nil
+	}
+	return float64(usage - inactive), nil
+}
+
+// IOBytes returns cumulative bytes read from and written to block devices
+func (g *Cgroup) IOBytes() (float64, float64, error) {
+	if g.Version == cgroupV1 {
+		return g.readBlkioServiceBytes()
+	}
+	buffer, err := os.ReadFile(path.Join(g.IODir, "io.stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	// each line is a device, e.g. 8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0
+	read, write := 0., 0.
+	for _, line := range strings.Split(string(buffer), "\n") {
+		for _, field := range strings.Fields(line) {
+			k, v, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to parse %q of io.stat: %s", field, err.Error())
+			}
+			switch k {
+			case "rbytes":
+				read += float64(n)
+			case "wbytes":
+				write += float64(n)
+			}
+		}
+	}
+	return read, write, nil
+}
+
+// readBlkioServiceBytes sums bytes of the devices in blkio.throttle.io_service_bytes,
+// which has lines like 8:0 Read 1024. The last line is the total without a device
+func (g *Cgroup) readBlkioServiceBytes() (float64, float64, error) {
+	buffer, err := os.ReadFile(path.Join(g.IODir, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return 0, 0, err
+	}
+	read, write := 0., 0.
+	for _, line := range strings.Split(string(buffer), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse %q of blkio: %s", line, err.Error())
+		}
+		switch fields[1] {
+		case "Read":
+			read += float64(n)
+		case "Write":
+			write += float64(n)
+		}
+	}
+	return read, write, nil
+}
+
+// PIDs returns the processes in the cgroup
+func (g *Cgroup) PIDs() ([]int32, error) {
+	buffer, err := os.ReadFile(path.Join(g.CPUDir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int32
+	for _, s := range strings.Fields(string(buffer)) {
+		pid, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, nil
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"path"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCgroupV2(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSysfsFile(t, path.Join(dir, "cgroup.controllers"), "cpuset cpu io memory pids\n")
+	writeTestSysfsFile(t, path.Join(dir, "cpu.stat"), "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n")
+	writeTestSysfsFile(t, path.Join(dir, "memory.current"), "28065792\n")
+	writeTestSysfsFile(t, path.Join(dir, "memory.stat"), "anon 11882496\nfile 12816384\nactive_file 6209536\ninactive_file 6606848\n")
+	writeTestSysfsFile(t, path.Join(dir, "io.stat"), "8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0\n179:0 rbytes=1000 wbytes=0 rios=3 wios=0 dbytes=0 dios=0\n")
+	writeTestSysfsFile(t, path.Join(dir, "cgroup.procs"), "123\n124\n")
+	g := CgroupFromRoot(dir)
+	assert.Equal(t, g.Version, cgroupV2)
+
+	cpuSeconds, err := g.CPUSeconds()
+	assert.NilError(t, err)
+	assert.Equal(t, cpuSeconds, 2.5)
+	_, err = g.CPUSecondsPerCPU()
+	assert.ErrorContains(t, err, "not available")
+
+	memory, err := g.MemoryWorkingSet()
+	assert.NilError(t, err)
+	assert.Equal(t, memory, 21458944.)
+
+	read, write, err := g.IOBytes()
+	assert.NilError(t, err)
+	assert.Equal(t, read, 2024.)
+	assert.Equal(t, write, 2048.)
+
+	pids, err := g.PIDs()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pids, []int32{123, 124})
+}
+
+func TestCgroupV1IO(t *testing.T) {
+	root := t.TempDir()
+	writeTestSysfsFile(t, path.Join(root, "blkio/kubepods/blkio.throttle.io_service_bytes"), `8:0 Read 4096
+8:0 Write 8192
+8:0 Sync 12288
+8:0 Async 0
+8:0 Total 12288
+Total 12288
+`)
+	g := NewCgroupV1(root, "kubepods")
+	assert.Equal(t, g.CPUDir, path.Join(root, "cpu,cpuacct/kubepods"))
+	read, write, err := g.IOBytes()
+	assert.NilError(t, err)
+	assert.Equal(t, read, 4096.)
+	assert.Equal(t, write, 8192.)
+}
+
+func TestResolveCgroupV1(t *testing.T) {
+	procfs := t.TempDir()
+	pod := "/kubepods/besteffort/pod" + testPodUID + "/" + testContainerID
+	writeTestSysfsFile(t, path.Join(procfs, "123/cgroup"), `12:memory:`+pod+`
+6:cpu,cpuacct:`+pod+`
+3:blkio:`+pod+`
+1:name=systemd:`+pod+`
+0::/
+`)
+	writeTestSysfsFile(t, path.Join(procfs, "123/mountinfo"), `1085 1084 0:118 / /sys/fs/cgroup ro,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw,mode=755
+1090 1085 0:30 `+pod+` /sys/fs/cgroup/cpu,cpuacct ro,nosuid,nodev,noexec,relatime master:12 - cgroup cgroup rw,cpu,cpuacct
+1091 1085 0:34 `+pod+` /sys/fs/cgroup/memory ro,nosuid,nodev,noexec,relatime master:16 - cgroup cgroup rw,memory
+1092 1085 0:27 `+pod+` /sys/fs/cgroup/blkio ro,nosuid,nodev,noexec,relatime master:9 - cgroup cgroup rw,blkio
+1093 1085 0:29 / /sys/fs/cgroup/unified ro,nosuid,nodev,noexec,relatime - cgroup2 cgroup2 rw
+`)
+	rootfs := path.Join(procfs, "123/root")
+	writeTestSysfsFile(t, path.Join(rootfs, "sys/fs/cgroup/cpu,cpuacct/cgroup.procs"), "1\n123\n")
+	writeTestSysfsFile(t, path.Join(rootfs, "sys/fs/cgroup/memory/cgroup.procs"), "1\n123\n")
+	writeTestSysfsFile(t, path.Join(rootfs, "sys/fs/cgroup/blkio/cgroup.procs"), "1\n123\n")
+	g, err := ResolveCgroup(procfs, 123)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, g, &Cgroup{
+		Version:   cgroupV1,
+		CPUDir:    path.Join(rootfs, "sys/fs/cgroup/cpu,cpuacct"),
+		MemoryDir: path.Join(rootfs, "sys/fs/cgroup/memory"),
+		IODir:     path.Join(rootfs, "sys/fs/cgroup/blkio"),
+	})
+}
+
+func TestResolveCgroupV2(t *testing.T) {
+	container := "/kubepods.slice/kubepods-besteffort.slice/cri-containerd-" + testContainerID + ".scope"
+	for _, tc := range []struct {
+		name       string
+		cgroupPath string
+		dir        string
+	}{
+		// the plugin and the controller are in the host's cgroup namespace
+		{name: "host namespace", cgroupPath: container, dir: "sys/fs/cgroup" + container},
+		// the plugin has a private cgroup namespace, so its cgroup is at the mount root and
+		// the path seen from the controller's namespace goes out of the controller's cgroup
+		{name: "private namespace", cgroupPath: "/../cri-containerd-" + testContainerID + ".scope", dir: "sys/fs/cgroup"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			procfs := t.TempDir()
+			writeTestSysfsFile(t, path.Join(procfs, "123/cgroup"), "0::"+tc.cgroupPath+"\n")
+			writeTestSysfsFile(t, path.Join(procfs, "123/mountinfo"), `1085 1084 0:118 / / rw,relatime - overlay overlay rw
+1090 1085 0:30 / /sys/fs/cgroup ro,nosuid,nodev,noexec,relatime - cgroup2 cgroup rw,nsdelegate
+`)
+			rootfs := path.Join(procfs, "123/root")
+			writeTestSysfsFile(t, path.Join(rootfs, "sys/fs/cgroup/cgroup.procs"), "")
+			writeTestSysfsFile(t, path.Join(rootfs, tc.dir, "cgroup.procs"), "1\n123\n")
+			g, err := ResolveCgroup(procfs, 123)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, g, NewCgroupV2(path.Join(rootfs, tc.dir)))
+		})
+	}
+}
+
+func TestResolveCgroupNoMount(t *testing.T) {
+	procfs := t.TempDir()
+	writeTestSysfsFile(t, path.Join(procfs, "123/cgroup"), "0::/\n")
+	writeTestSysfsFile(t, path.Join(procfs, "123/mountinfo"), "1085 1084 0:118 / / rw,relatime - overlay overlay rw\n")
+	_, err := ResolveCgroup(procfs, 123)
+	assert.ErrorContains(t, err, "no cgroup mount")
+}
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// LoadConfigFile reads a JSON config file into c. Keys are the field names of
+// ControllerConfig, e.g. {"LogLevel": "debug", "LogFormat": "json"}. Fields that
+// are not in the file keep their values
+func LoadConfigFile(filePath string, c *ControllerConfig) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %s", filePath, err.Error())
+	}
+	return nil
+}
@@ -2,8 +2,7 @@ package controller
 
 import (
 	"fmt"
-	"os"
-	"path"
+	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,16 +11,20 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
 	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
-	"github.com/waggle-sensor/edge-scheduler/pkg/logger"
 )
 
+// cpuSecondsTotalEntry is the entry of CPU events that carries the cumulative CPU time
+// of the plugin's cgroup in seconds
+const cpuSecondsTotalEntry = "cpu_seconds_total"
+
 type CPUPerformanceLogging struct {
-	CgroupDir         string
+	Cgroup            *Cgroup
 	Notifier          *interfacing.Notifier
 	quit              chan struct{}
 	interval          int
 	lastTotalCPUUsed  float64
 	lastTotalCPUUsedT time.Time
+	log               *slog.Logger
 
 	promCPUSecondsPerCPU *prometheus.Desc
 	promCPUSeconds       *prometheus.Desc
@@ -30,12 +33,13 @@ type CPUPerformanceLogging struct {
 
 func NewCPUPerformanceLogging(c ControllerConfig) *CPUPerformanceLogging {
 	return &CPUPerformanceLogging{
-		CgroupDir:         c.AppCgroupDir,
+		Cgroup:            CgroupFromRoot(c.AppCgroupDir),
 		Notifier:          interfacing.NewNotifier(),
 		quit:              make(chan struct{}),
 		interval:          c.PerformanceCollectionInterval,
 		lastTotalCPUUsed:  0,
 		lastTotalCPUUsedT: time.Now(),
+		log:               componentLogger("cpu"),
 
 		promCPUSecondsPerCPU: prometheus.NewDesc(
 			"plugin_per_cpu_seconds_total",
@@ -65,27 +69,36 @@ func (c *CPUPerformanceLogging) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *CPUPerformanceLogging) Collect(ch chan<- prometheus.Metric) {
-	if values, err := c.ReadCPUSecondsPerCPU(); err != nil {
-		logger.Error.Printf("Error on ReadCPUSecondsPerCPU: %s", err.Error())
+	start := time.Now()
+	total, err := c.Cgroup.CPUSeconds()
+	observeCollection("cpu", start, err)
+	if err != nil {
+		c.log.Error("failed to read cpu seconds", "error", err)
 	} else {
-		total := 0.
-		for index, cpuSecond := range values {
-			ch <- prometheus.MustNewConstMetric(
-				c.promCPUSecondsPerCPU,
-				prometheus.CounterValue,
-				cpuSecond,
-				fmt.Sprint(index),
-			)
-			total += cpuSecond
-		}
 		ch <- prometheus.MustNewConstMetric(
 			c.promCPUSeconds,
 			prometheus.CounterValue,
 			total,
 		)
 	}
-	if workingSet, err := c.ReadMemory(); err != nil {
-		logger.Error.Printf("Error on ReadCPUSecondsPerCPU: %s", err.Error())
+	// cgroup v2 does not account CPU time per core
+	if c.Cgroup.Version == cgroupV1 {
+		if values, err := c.ReadCPUSecondsPerCPU(); err == nil {
+			for index, cpuSecond := range values {
+				ch <- prometheus.MustNewConstMetric(
+					c.promCPUSecondsPerCPU,
+					prometheus.CounterValue,
+					cpuSecond,
+					fmt.Sprint(index),
+				)
+			}
+		}
+	}
+	start = time.Now()
+	workingSet, err := c.ReadMemory()
+	observeCollection("memory", start, err)
+	if err != nil {
+		c.log.Error("failed to read memory", "error", err)
 	} else {
 		ch <- prometheus.MustNewConstMetric(
 			c.promMemoryWorkingSet,
@@ -96,103 +109,79 @@ func (c *CPUPerformanceLogging) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (c *CPUPerformanceLogging) ReadCPUSecondsPerCPU() ([]float64, error) {
-	cpuacctSubDir := "cpu,cpuacct"
-	cpuacctUsagePerCPUFile := "cpuacct.usage_percpu"
-	buffer, err := os.ReadFile(path.Join(path.Join(c.CgroupDir, cpuacctSubDir, cpuacctUsagePerCPUFile)))
-	if err != nil {
-		return []float64{}, err
-	}
-	values := strings.Split(strings.TrimSpace(string(buffer)), " ")
-	out := make([]float64, len(values))
-	for index, strNanoSeconds := range values {
-		if strNanoSeconds == "" {
-			logger.Error.Printf("%v has an empty string. skipping", values)
-			continue
-		}
-		nanoSeconds, err := strconv.ParseUint(strings.TrimSpace(strNanoSeconds), 10, 64)
-		if err != nil {
-			return out, err
-		}
-		out[index] = float64(nanoSeconds) / 1e9
-	}
-	return out, nil
+	return c.Cgroup.CPUSecondsPerCPU()
 }
 
-// ReadCPUPerc reads cpuacct.stat file and returns an averaged per-second CPU utiltizaiton since
-// the last read. The expected format is
-//
-// user x
-//
-// system y
+// ReadCPUPerc returns an averaged per-second CPU utiltizaiton in percent since the last read
 func (c *CPUPerformanceLogging) ReadCPUPerc() (float64, error) {
-	values, err := c.ReadCPUSecondsPerCPU()
+	total, err := c.Cgroup.CPUSeconds()
 	if err != nil {
 		return 0., err
 	}
-	total := 0.
-	for _, v := range values {
-		total += v
-	}
 	if total < 0.1 {
 		return 0., nil
 	}
 	delta := total - c.lastTotalCPUUsed
 	deltaT := time.Since(c.lastTotalCPUUsedT).Seconds()
+	c.lastTotalCPUUsed = total
 	c.lastTotalCPUUsedT = time.Now()
-	// delta := (total - c.lastTotalCPUUsed) / uint64(deltaT)
 	return delta / deltaT * 100, nil
 }
 
-// ReadMemory returns current container workingset memory in bytes
-// workingset memory is the amount that cannot be evicted and
-// calculated by total used memory - total inactive file
-func (c *CPUPerformanceLogging) ReadMemory() (float64, error) {
-	memorySubDir := "memory"
-	totalUsedMemoryFile := "memory.usage_in_bytes"
-	statFile := "memory.stat"
-	totalUsedMemoryByte, err := os.ReadFile(path.Join(c.CgroupDir, memorySubDir, totalUsedMemoryFile))
-	if err != nil {
-		return 0, err
-	}
-	totalUsedMemory, err := strconv.ParseUint(strings.TrimSpace(string(totalUsedMemoryByte)), 10, 64)
-	if err != nil {
-		return 0, err
-	}
-	statBuffer, err := os.ReadFile(path.Join(c.CgroupDir, memorySubDir, statFile))
-	if err != nil {
-		return 0, err
-	}
-	totalInactive, err := getUintValueFromMatch(statBuffer, `total_inactive_file [0-9]+`)
+// resetCPUPerc starts the next utilization from the CPU time used so far, so the first
+// sample does not spread the lifetime usage of the cgroup over the time since creation
+func (c *CPUPerformanceLogging) resetCPUPerc() {
+	total, err := c.Cgroup.CPUSeconds()
 	if err != nil {
-		return 0, err
+		c.log.Debug("failed to read cpu seconds", "error", err)
+		return
 	}
-	return float64(totalUsedMemory - totalInactive), nil
+	c.lastTotalCPUUsed = total
+	c.lastTotalCPUUsedT = time.Now()
+}
+
+// ReadMemory returns current container workingset memory in bytes
+func (c *CPUPerformanceLogging) ReadMemory() (float64, error) {
+	return c.Cgroup.MemoryWorkingSet()
 }
 
 func (c *CPUPerformanceLogging) Stop() {
 	c.quit <- struct{}{}
 }
 
+// setInterval changes the sampling interval. It takes effect on the next Run
+func (c *CPUPerformanceLogging) setInterval(seconds int) {
+	c.interval = seconds
+}
+
 func (c *CPUPerformanceLogging) Run() {
+	c.resetCPUPerc()
 	ticker := time.NewTicker(time.Duration(c.interval) * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			if mem, err := c.ReadMemory(); err == nil {
+			start := time.Now()
+			mem, err := c.ReadMemory()
+			observeCollection("memory", start, err)
+			if err == nil {
 				e := datatype.NewEventBuilder(datatype.EventPluginPerfMem).
 					AddValue(mem).
 					Build()
 				c.Notifier.Notify(e)
 			} else {
-				logger.Error.Println(err.Error())
+				c.log.Error("failed to collect", "error", err)
 			}
-			if cpu, err := c.ReadCPUPerc(); err == nil {
+			start = time.Now()
+			cpu, err := c.ReadCPUPerc()
+			observeCollection("cpu", start, err)
+			if err == nil {
 				e := datatype.NewEventBuilder(datatype.EventPluginPerfCPU).
 					AddValue(cpu).
+					AddEntry(cpuSecondsTotalEntry, c.lastTotalCPUUsed).
 					Build()
 				c.Notifier.Notify(e)
 			} else {
-				logger.Error.Println(err.Error())
+				c.log.Error("failed to collect", "error", err)
 			}
 		case <-c.quit:
 			ticker.Stop()
@@ -205,7 +194,7 @@ func getUintValueFromMatch(buf []byte, matchString string) (uint64, error) {
 	re := regexp.MustCompile(matchString)
 	matches := re.FindStringSubmatch(string(buf[:]))
 	if len(matches) != 1 {
-		return 0, fmt.Errorf("failed to get total_inactive_file value from %s", buf)
+		return 0, fmt.Errorf("failed to find %q from %s", matchString, buf)
 	}
 	sp := strings.Split(matches[0], " ")
 	if len(sp) != 2 {
@@ -220,3 +220,22 @@ func TestPrometheusEndpoint(t *testing.T) {
 		t.Errorf("unexpected metric count, got %d, want %d", got, expected)
 	}
 }
+
+func TestReadCPUPercAfterReset(t *testing.T) {
+	root := t.TempDir()
+	usage := path.Join(root, "cpu,cpuacct", "cpuacct.usage_percpu")
+	// the plugin used 100 seconds of CPU time before the collector starts
+	writeTestSysfsFile(t, usage, "50000000000 50000000000")
+	c := NewCPUPerformanceLogging(ControllerConfig{
+		AppCgroupDir: root,
+	})
+	c.resetCPUPerc()
+	assert.Equal(t, c.lastTotalCPUUsed, 100.)
+
+	// then 5 seconds in 10 seconds
+	writeTestSysfsFile(t, usage, "52500000000 52500000000")
+	c.lastTotalCPUUsedT = time.Now().Add(-10 * time.Second)
+	cpuUtil, err := c.ReadCPUPerc()
+	assert.NilError(t, err)
+	assert.Assert(t, cpuUtil > 49 && cpuUtil < 51, "got %f", cpuUtil)
+}
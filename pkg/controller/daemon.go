@@ -0,0 +1,390 @@
+package controller
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	EventPluginPerfIORead  datatype.EventType = "sys.plugin.perf.io.read"
+	EventPluginPerfIOWrite datatype.EventType = "sys.plugin.perf.io.write"
+)
+
+// kubepodsDirs are the top cgroups of Kubernetes pods with the cgroupfs and systemd drivers
+var kubepodsDirs = []string{"kubepods", "kubepods.slice"}
+
+// podDirPattern matches a pod cgroup, e.g. pod<uid> with the cgroupfs driver and
+// kubepods-besteffort-pod<uid with underscores>.slice with the systemd driver
+var podDirPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+// nodeMetadataKeys are the metadata of the daemon that apply to every plugin on the node
+var nodeMetadataKeys = []string{"node", "vsn", "host"}
+
+// PluginContainer is a plugin container found in the kubepods cgroup hierarchy.
+// Metadata has the pod and the container of the plugin
+type PluginContainer struct {
+	PodUID   string
+	ID       string
+	Cgroup   *Cgroup
+	Metadata Metadata
+
+	lastCPUSeconds float64
+	lastRead       float64
+	lastWrite      float64
+	lastT          time.Time
+}
+
+// NodeDaemon monitors every plugin container on the node from the host's kubepods cgroup
+// hierarchy. A container is taken as a plugin if its processes carry the Waggle plugin
+// environment variables, which leaves out pause and other system containers. The hierarchy
+// is rescanned every interval, so plugins started later are picked up
+type NodeDaemon struct {
+	CgroupRoot string
+	ProcDir    string
+	Notifier   *interfacing.Notifier
+	node       Metadata
+	smi        *NvidiaSMI
+	interval   int
+	quit       chan struct{}
+	log        *slog.Logger
+
+	mu         sync.Mutex
+	containers map[string]*PluginContainer
+
+	promCPUSeconds       *prometheus.Desc
+	promMemoryWorkingSet *prometheus.Desc
+	promIOReadBytes      *prometheus.Desc
+	promIOWriteBytes     *prometheus.Desc
+	promGPUUtilization   *prometheus.Desc
+	promGPUMemoryUsed    *prometheus.Desc
+}
+
+func NewNodeDaemon(c ControllerConfig, env []string) *NodeDaemon {
+	d := &NodeDaemon{
+		CgroupRoot: c.HostCgroupRoot,
+		ProcDir:    c.ProcfsRoot,
+		Notifier:   interfacing.NewNotifier(),
+		node:       nodeMetadata(env),
+		interval:   c.PerformanceCollectionInterval,
+		quit:       make(chan struct{}),
+		log:        componentLogger("daemon"),
+		containers: map[string]*PluginContainer{},
+
+		promCPUSeconds:       newContainerDesc("plugin_cpu_seconds_total", "Cumulative plugin cpu time consumped in seconds"),
+		promMemoryWorkingSet: newContainerDesc("plugin_memory_workingset_bytes", "Amount of working set memory in bytes"),
+		promIOReadBytes:      newContainerDesc("plugin_io_read_bytes_total", "Cumulative bytes read from block devices"),
+		promIOWriteBytes:     newContainerDesc("plugin_io_write_bytes_total", "Cumulative bytes written to block devices"),
+		promGPUUtilization:   newContainerDesc("plugin_gpu_utilization_percent", "GPU SM utilization of the plugin in percent"),
+		promGPUMemoryUsed:    newContainerDesc("plugin_gpu_memory_used_bytes", "GPU memory used by the plugin in bytes"),
+	}
+	// GPU use of a container needs per-process accounting, which only nvidia-smi provides
+	if c.EnableGPUPerformanceLogging {
+		smi := NewNvidiaSMI(c.NvidiaSMIPath)
+		if _, err := exec.LookPath(smi.Path); err == nil {
+			d.smi = smi
+		} else {
+			d.log.Warn("GPU performance is not collected as nvidia-smi is not found", "path", smi.Path)
+		}
+	}
+	return d
+}
+
+func newContainerDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, []string{"pod", "container"}, nil)
+}
+
+func nodeMetadata(env []string) Metadata {
+	all := Metadata{}
+	all.addEnv(env)
+	m := Metadata{}
+	for _, k := range nodeMetadataKeys {
+		if v, found := all[k]; found {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// findPluginContainers walks the kubepods hierarchy and returns the plugin containers
+// in the pod cgroups. Cgroups that fail to read, e.g. of pods removed while scanning,
+// are skipped
+func (d *NodeDaemon) findPluginContainers() ([]*PluginContainer, error) {
+	// the pod cgroups of v1 are found from the cpuacct hierarchy
+	v2 := isCgroupV2(d.CgroupRoot)
+	base := d.CgroupRoot
+	if !v2 {
+		base = path.Join(d.CgroupRoot, "cpu,cpuacct")
+	}
+	var found []*PluginContainer
+	for _, dir := range kubepodsDirs {
+		top := path.Join(base, dir)
+		if _, err := os.Stat(top); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(top, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				d.log.Warn("failed to scan cgroup", "dir", p, "error", err)
+				return nil
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			m := podDirPattern.FindStringSubmatch(entry.Name())
+			if m == nil {
+				return nil
+			}
+			podUID := strings.ReplaceAll(m[1], "_", "-")
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				d.log.Warn("failed to scan pod cgroup", "dir", p, "error", err)
+				return fs.SkipDir
+			}
+			for _, child := range entries {
+				id := containerIDPattern.FindString(child.Name())
+				if !child.IsDir() || id == "" {
+					continue
+				}
+				cgroup := NewCgroupV2(path.Join(p, child.Name()))
+				if !v2 {
+					cgroup = NewCgroupV1(d.CgroupRoot, strings.TrimPrefix(path.Join(p, child.Name()), base))
+				}
+				if c := d.inspectContainer(podUID, id[:12], cgroup); c != nil {
+					found = append(found, c)
+				}
+			}
+			return fs.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// inspectContainer returns the container if it runs a plugin
+func (d *NodeDaemon) inspectContainer(podUID string, id string, cgroup *Cgroup) *PluginContainer {
+	pids, err := cgroup.PIDs()
+	if err != nil || len(pids) == 0 {
+		return nil
+	}
+	m := GatherMetadata(d.ProcDir, "", nil, pids[0])
+	if m["app_id"] == "" && m["plugin_name"] == "" {
+		return nil
+	}
+	// the pod name is taken from the hostname of the plugin
+	if m["pod"] == "" {
+		m["pod"] = podUID
+	}
+	m["container"] = id
+	for k, v := range d.node {
+		if _, exists := m[k]; !exists {
+			m[k] = v
+		}
+	}
+	return &PluginContainer{
+		PodUID:   podUID,
+		ID:       id,
+		Cgroup:   cgroup,
+		Metadata: m,
+	}
+}
+
+// Scan rescans the kubepods hierarchy. Containers already known keep their last samples
+func (d *NodeDaemon) Scan() error {
+	found, err := d.findPluginContainers()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	containers := map[string]*PluginContainer{}
+	for _, c := range found {
+		if known, exists := d.containers[c.ID]; exists {
+			containers[c.ID] = known
+			continue
+		}
+		d.log.Info("plugin container found", c.Metadata.Attrs()...)
+		containers[c.ID] = c
+	}
+	for id, c := range d.containers {
+		if _, exists := containers[id]; !exists {
+			d.log.Info("plugin container is gone", c.Metadata.Attrs()...)
+		}
+	}
+	d.containers = containers
+	return nil
+}
+
+// Containers returns the plugin containers found by the last scan sorted by pod and container
+func (d *NodeDaemon) Containers() []*PluginContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	containers := make([]*PluginContainer, 0, len(d.containers))
+	for _, c := range d.containers {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Metadata["pod"] != containers[j].Metadata["pod"] {
+			return containers[i].Metadata["pod"] < containers[j].Metadata["pod"]
+		}
+		return containers[i].ID < containers[j].ID
+	})
+	return containers
+}
+
+// Metadata returns the metadata of the container. It returns the node's metadata
+// if the container is not known
+func (d *NodeDaemon) Metadata(id string) Metadata {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c, exists := d.containers[id]; exists {
+		return c.Metadata
+	}
+	return d.node
+}
+
+// readGPU returns SM utilization and GPU memory used by every process. It returns
+// nil maps if GPU performance is not collected
+func (d *NodeDaemon) readGPU() (map[int32]float64, map[int32]float64) {
+	if d.smi == nil {
+		return nil, nil
+	}
+	start := time.Now()
+	util, used, err := d.smi.readProcesses()
+	observeCollection("gpu", start, err)
+	if err != nil {
+		d.log.Error("failed to read GPU metrics", "error", err)
+		return nil, nil
+	}
+	return util, used
+}
+
+func (d *NodeDaemon) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.promCPUSeconds
+	ch <- d.promMemoryWorkingSet
+	ch <- d.promIOReadBytes
+	ch <- d.promIOWriteBytes
+	ch <- d.promGPUUtilization
+	ch <- d.promGPUMemoryUsed
+}
+
+func (d *NodeDaemon) Collect(ch chan<- prometheus.Metric) {
+	gpuUtil, gpuUsed := d.readGPU()
+	for _, c := range d.Containers() {
+		labels := []string{c.Metadata["pod"], c.ID}
+		start := time.Now()
+		cpuSeconds, err := c.Cgroup.CPUSeconds()
+		observeCollection("cpu", start, err)
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(d.promCPUSeconds, prometheus.CounterValue, cpuSeconds, labels...)
+		}
+		start = time.Now()
+		workingSet, err := c.Cgroup.MemoryWorkingSet()
+		observeCollection("memory", start, err)
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(d.promMemoryWorkingSet, prometheus.GaugeValue, workingSet, labels...)
+		}
+		start = time.Now()
+		read, write, err := c.Cgroup.IOBytes()
+		observeCollection("io", start, err)
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(d.promIOReadBytes, prometheus.CounterValue, read, labels...)
+			ch <- prometheus.MustNewConstMetric(d.promIOWriteBytes, prometheus.CounterValue, write, labels...)
+		}
+		if gpuUtil != nil {
+			if pids, err := c.Cgroup.PIDs(); err == nil {
+				smUtil, memory := sumProcessGPU(gpuUtil, gpuUsed, pids)
+				ch <- prometheus.MustNewConstMetric(d.promGPUUtilization, prometheus.GaugeValue, smUtil, labels...)
+				ch <- prometheus.MustNewConstMetric(d.promGPUMemoryUsed, prometheus.GaugeValue, memory, labels...)
+			}
+		}
+	}
+}
+
+// sample notifies performance events of the container. CPU utilization and I/O rates
+// are taken from the change since the last sample, so they are not notified on the first
+func (d *NodeDaemon) sample(c *PluginContainer, now time.Time, gpuUtil map[int32]float64, gpuUsed map[int32]float64) {
+	notify := func(eventType datatype.EventType, value float64) {
+		e := datatype.NewEventBuilder(eventType).
+			AddValue(value).
+			AddEntry("container", c.ID).
+			Build()
+		d.Notifier.Notify(e)
+	}
+	elapsed := now.Sub(c.lastT).Seconds()
+	first := c.lastT.IsZero()
+	c.lastT = now
+	if workingSet, err := c.Cgroup.MemoryWorkingSet(); err == nil {
+		notify(datatype.EventPluginPerfMem, workingSet)
+	} else {
+		d.log.Error("failed to read memory", "container", c.ID, "error", err)
+	}
+	if cpuSeconds, err := c.Cgroup.CPUSeconds(); err == nil {
+		if !first && elapsed > 0 {
+			notify(datatype.EventPluginPerfCPU, (cpuSeconds-c.lastCPUSeconds)/elapsed*100)
+		}
+		c.lastCPUSeconds = cpuSeconds
+	} else {
+		d.log.Error("failed to read cpu seconds", "container", c.ID, "error", err)
+	}
+	if read, write, err := c.Cgroup.IOBytes(); err == nil {
+		if !first && elapsed > 0 {
+			notify(EventPluginPerfIORead, (read-c.lastRead)/elapsed)
+			notify(EventPluginPerfIOWrite, (write-c.lastWrite)/elapsed)
+		}
+		c.lastRead, c.lastWrite = read, write
+	} else {
+		d.log.Error("failed to read io", "container", c.ID, "error", err)
+	}
+	if gpuUtil != nil {
+		if pids, err := c.Cgroup.PIDs(); err == nil {
+			smUtil, memory := sumProcessGPU(gpuUtil, gpuUsed, pids)
+			notify(datatype.EventPluginPerfGPU, smUtil)
+			notify(EventPluginPerfGPUMemory, memory)
+		}
+	}
+}
+
+func (d *NodeDaemon) Stop() {
+	d.quit <- struct{}{}
+}
+
+// setInterval changes the sampling interval. It takes effect on the next Run
+func (d *NodeDaemon) setInterval(seconds int) {
+	d.interval = seconds
+}
+
+// Run rescans the kubepods hierarchy and samples every plugin container every interval
+func (d *NodeDaemon) Run() {
+	ticker := time.NewTicker(time.Duration(d.interval) * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Scan(); err != nil {
+				d.log.Error("failed to scan plugin containers", "cgroup_root", d.CgroupRoot, "error", err)
+				continue
+			}
+			now := time.Now()
+			gpuUtil, gpuUsed := d.readGPU()
+			for _, c := range d.Containers() {
+				d.sample(c, now, gpuUtil, gpuUsed)
+			}
+		case <-d.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
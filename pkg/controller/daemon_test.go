@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+const (
+	testPodUID        = "5c7b6b1e-3c7a-4d8e-9f10-2b3c4d5e6f70"
+	testContainerID   = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPauseID       = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	testPluginPID     = 123
+	testPausePID      = 124
+	testPluginPodName = "plugin-test-pipeline-abcde"
+)
+
+// writeTestProcess writes the environment and the cgroup of a process
+func writeTestProcess(t *testing.T, procDir string, pid int, env []string, cgroup string) {
+	writeTestSysfsFile(t, path.Join(procDir, fmt.Sprint(pid), "environ"), strings.Join(env, "\x00"))
+	writeTestSysfsFile(t, path.Join(procDir, fmt.Sprint(pid), "cgroup"), cgroup)
+}
+
+func newTestNodeDaemon(t *testing.T, cgroupRoot string) *NodeDaemon {
+	procDir := t.TempDir()
+	writeTestProcess(t, procDir, testPluginPID, []string{
+		"WAGGLE_APP_ID=app",
+		"WAGGLE_PLUGIN_NAME=test-pipeline",
+		"HOSTNAME=" + testPluginPodName,
+	}, "0::/kubepods/besteffort/pod"+testPodUID+"/"+testContainerID+"\n")
+	writeTestProcess(t, procDir, testPausePID, nil, "0::/kubepods/besteffort/pod"+testPodUID+"/"+testPauseID+"\n")
+	d := NewNodeDaemon(ControllerConfig{HostCgroupRoot: cgroupRoot, PerformanceCollectionInterval: 1}, []string{"WAGGLE_NODE_ID=000048b02d15bc7c", "HOSTNAME=plugin-controller-xyz"})
+	d.ProcDir = procDir
+	return d
+}
+
+func TestNodeDaemonCgroupV2(t *testing.T) {
+	root := t.TempDir()
+	writeTestSysfsFile(t, path.Join(root, "cgroup.controllers"), "cpuset cpu io memory pids\n")
+	// the systemd driver replaces dashes of the pod UID with underscores
+	pod := path.Join(root, "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod"+strings.ReplaceAll(testPodUID, "-", "_")+".slice")
+	plugin := path.Join(pod, "cri-containerd-"+testContainerID+".scope")
+	writeTestSysfsFile(t, path.Join(plugin, "cgroup.controllers"), "cpu io memory pids\n")
+	writeTestSysfsFile(t, path.Join(plugin, "cgroup.procs"), fmt.Sprintln(testPluginPID))
+	writeTestSysfsFile(t, path.Join(plugin, "cpu.stat"), "usage_usec 1000000\n")
+	writeTestSysfsFile(t, path.Join(plugin, "memory.current"), "2000\n")
+	writeTestSysfsFile(t, path.Join(plugin, "memory.stat"), "inactive_file 500\n")
+	writeTestSysfsFile(t, path.Join(plugin, "io.stat"), "8:0 rbytes=100 wbytes=200 rios=1 wios=1 dbytes=0 dios=0\n")
+	pause := path.Join(pod, "cri-containerd-"+testPauseID+".scope")
+	writeTestSysfsFile(t, path.Join(pause, "cgroup.procs"), fmt.Sprintln(testPausePID))
+
+	d := newTestNodeDaemon(t, root)
+	assert.NilError(t, d.Scan())
+	containers := d.Containers()
+	assert.Equal(t, len(containers), 1)
+	c := containers[0]
+	assert.Equal(t, c.PodUID, testPodUID)
+	assert.Equal(t, c.ID, testContainerID[:12])
+	assert.Equal(t, c.Cgroup.Version, cgroupV2)
+	assert.Equal(t, c.Metadata["pod"], testPluginPodName)
+	assert.Equal(t, c.Metadata["plugin_name"], "test-pipeline")
+	assert.Equal(t, c.Metadata["node"], "000048b02d15bc7c")
+
+	expected := fmt.Sprintf(`
+# HELP plugin_cpu_seconds_total Cumulative plugin cpu time consumped in seconds
+# TYPE plugin_cpu_seconds_total counter
+plugin_cpu_seconds_total{container="%[1]s",pod="%[2]s"} 1
+# HELP plugin_io_write_bytes_total Cumulative bytes written to block devices
+# TYPE plugin_io_write_bytes_total counter
+plugin_io_write_bytes_total{container="%[1]s",pod="%[2]s"} 200
+# HELP plugin_memory_workingset_bytes Amount of working set memory in bytes
+# TYPE plugin_memory_workingset_bytes gauge
+plugin_memory_workingset_bytes{container="%[1]s",pod="%[2]s"} 1500
+`, testContainerID[:12], testPluginPodName)
+	assert.NilError(t, testutil.CollectAndCompare(d, strings.NewReader(expected),
+		"plugin_cpu_seconds_total", "plugin_io_write_bytes_total", "plugin_memory_workingset_bytes"))
+
+	ch := make(chan datatype.Event, 10)
+	d.Notifier.Subscribe(ch)
+	now := time.Now()
+	d.sample(c, now, nil, nil)
+	// only memory is notified on the first sample
+	e := <-ch
+	assert.Equal(t, e.Type, datatype.EventPluginPerfMem)
+	assert.Equal(t, e.Meta["container"], testContainerID[:12])
+	writeTestSysfsFile(t, path.Join(plugin, "cpu.stat"), "usage_usec 3000000\n")
+	writeTestSysfsFile(t, path.Join(plugin, "io.stat"), "8:0 rbytes=300 wbytes=200 rios=1 wios=1 dbytes=0 dios=0\n")
+	d.sample(c, now.Add(2*time.Second), nil, nil)
+	values := map[datatype.EventType]float64{}
+	for i := 0; i < 4; i++ {
+		e := <-ch
+		values[e.Type], _ = eventValue(e)
+	}
+	assert.Equal(t, values[datatype.EventPluginPerfCPU], 100.)
+	assert.Equal(t, values[EventPluginPerfIORead], 100.)
+	assert.Equal(t, values[EventPluginPerfIOWrite], 0.)
+	assert.DeepEqual(t, d.Metadata(c.ID), c.Metadata)
+}
+
+func TestNodeDaemonCgroupV1(t *testing.T) {
+	root := t.TempDir()
+	rel := "kubepods/besteffort/pod" + testPodUID + "/" + testContainerID
+	writeTestSysfsFile(t, path.Join(root, "cpu,cpuacct", rel, "cgroup.procs"), fmt.Sprintln(testPluginPID))
+	writeTestSysfsFile(t, path.Join(root, "cpu,cpuacct", rel, "cpuacct.usage_percpu"), "1000000000 2000000000 \n")
+	writeTestSysfsFile(t, path.Join(root, "memory", rel, "memory.usage_in_bytes"), "2000\n")
+	writeTestSysfsFile(t, path.Join(root, "memory", rel, "memory.stat"), "inactive_file 100\ntotal_inactive_file 500\n")
+	pauseRel := "kubepods/besteffort/pod" + testPodUID + "/" + testPauseID
+	writeTestSysfsFile(t, path.Join(root, "cpu,cpuacct", pauseRel, "cgroup.procs"), fmt.Sprintln(testPausePID))
+
+	d := newTestNodeDaemon(t, root)
+	assert.NilError(t, d.Scan())
+	containers := d.Containers()
+	assert.Equal(t, len(containers), 1)
+	c := containers[0]
+	assert.Equal(t, c.Cgroup.MemoryDir, path.Join(root, "memory", rel))
+	cpuSeconds, err := c.Cgroup.CPUSeconds()
+	assert.NilError(t, err)
+	assert.Equal(t, cpuSeconds, 3.)
+	memory, err := c.Cgroup.MemoryWorkingSet()
+	assert.NilError(t, err)
+	assert.Equal(t, memory, 1500.)
+}
+
+func TestNodeDaemonUnreadablePod(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads the cgroup regardless of its permission")
+	}
+	root := t.TempDir()
+	rel := "kubepods/besteffort/pod" + testPodUID + "/" + testContainerID
+	writeTestSysfsFile(t, path.Join(root, "cpu,cpuacct", rel, "cgroup.procs"), fmt.Sprintln(testPluginPID))
+	// a pod that fails to read is scanned before the plugin's pod
+	unreadable := path.Join(root, "cpu,cpuacct", "kubepods/besteffort/pod00000000-0000-0000-0000-000000000000")
+	assert.NilError(t, os.MkdirAll(unreadable, 0755))
+	assert.NilError(t, os.Chmod(unreadable, 0))
+	t.Cleanup(func() { os.Chmod(unreadable, 0755) })
+
+	d := newTestNodeDaemon(t, root)
+	assert.NilError(t, d.Scan())
+	assert.Equal(t, len(d.Containers()), 1)
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	EventPluginPerfEnergy datatype.EventType = "sys.plugin.perf.energy"
+)
+
+// jetsonBoardInputRails are INA3221 rails that measure the whole board input.
+// When one of them exists, the other rails are part of it and not added
+var jetsonBoardInputRails = map[string]bool{
+	"VDD_IN":    true,
+	"POM_5V_IN": true,
+}
+
+var raplPackageZone = regexp.MustCompile(`^intel-rapl:[0-9]+$`)
+
+// EnergyEstimation estimates energy consumed by the plugin. It reads the board power from
+// Jetson INA3221 power rails or from Intel RAPL and attributes the power to the plugin
+// in proportion to the plugin's share of the CPU cores and of the GPU load
+type EnergyEstimation struct {
+	SysfsRoot string
+	Notifier  *interfacing.Notifier
+	quit      chan struct{}
+	interval  int
+	numCPU    int
+	log       *slog.Logger
+
+	mu           sync.Mutex
+	cpuPerc      float64
+	gpuLoad      float64
+	gpuSeen      bool
+	energyJoules float64
+	pluginPower  float64
+	lastRAPL     map[string]uint64
+	lastRAPLT    time.Time
+
+	promEnergy *prometheus.Desc
+	promPower  *prometheus.Desc
+}
+
+func NewEnergyEstimation(c ControllerConfig) *EnergyEstimation {
+	return &EnergyEstimation{
+		SysfsRoot: c.SysfsRoot,
+		Notifier:  interfacing.NewNotifier(),
+		quit:      make(chan struct{}),
+		interval:  c.PerformanceCollectionInterval,
+		numCPU:    runtime.NumCPU(),
+		log:       componentLogger("energy"),
+		lastRAPL:  map[string]uint64{},
+
+		promEnergy: prometheus.NewDesc(
+			"plugin_energy_joules_total",
+			"Estimated cumulative energy consumed by the plugin in joules",
+			nil,
+			nil,
+		),
+		promPower: prometheus.NewDesc(
+			"plugin_power_watts",
+			"Estimated power drawn by the plugin in watts",
+			nil,
+			nil,
+		),
+	}
+}
+
+func readFloatFromFile(filePath string) (float64, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(buf)), 64)
+}
+
+// sumRails returns the board input rail if exists. Otherwise, it returns the sum of all rails
+func sumRails(rails map[string]float64) float64 {
+	total := 0.
+	for name, power := range rails {
+		if jetsonBoardInputRails[name] {
+			return power
+		}
+		total += power
+	}
+	return total
+}
+
+// readJetsonPower returns the board power in watts from INA3221 rails. Older L4T releases
+// expose power of the rails via the ina3221x IIO driver and newer releases expose voltage
+// and current via the ina3221 hwmon driver
+func (en *EnergyEstimation) readJetsonPower() (float64, error) {
+	rails := map[string]float64{}
+	iioPowers, _ := filepath.Glob(path.Join(en.SysfsRoot, "bus/i2c/drivers/ina3221x/*/iio:device*/in_power*_input"))
+	for _, powerFile := range iioPowers {
+		milliWatts, err := readFloatFromFile(powerFile)
+		if err != nil {
+			return 0, err
+		}
+		channel := strings.TrimSuffix(strings.TrimPrefix(path.Base(powerFile), "in_power"), "_input")
+		name := powerFile
+		if buf, err := os.ReadFile(path.Join(path.Dir(powerFile), "rail_name_"+channel)); err == nil {
+			name = strings.TrimSpace(string(buf))
+		}
+		rails[name] = milliWatts / 1000.
+	}
+	hwmonLabels, _ := filepath.Glob(path.Join(en.SysfsRoot, "bus/i2c/drivers/ina3221/*/hwmon/hwmon*/in*_label"))
+	for _, labelFile := range hwmonLabels {
+		channel := strings.TrimSuffix(strings.TrimPrefix(path.Base(labelFile), "in"), "_label")
+		milliVolts, err := readFloatFromFile(path.Join(path.Dir(labelFile), "in"+channel+"_input"))
+		if err != nil {
+			continue
+		}
+		milliAmps, err := readFloatFromFile(path.Join(path.Dir(labelFile), "curr"+channel+"_input"))
+		if err != nil {
+			continue
+		}
+		buf, err := os.ReadFile(labelFile)
+		if err != nil {
+			continue
+		}
+		rails[strings.TrimSpace(string(buf))] = milliVolts * milliAmps / 1e6
+	}
+	if len(rails) == 0 {
+		return 0, fmt.Errorf("no INA3221 power rail found")
+	}
+	return sumRails(rails), nil
+}
+
+// readRAPLPower returns the power of all CPU packages in watts. RAPL reports
+// cumulative energy, so the power is averaged since the previous read
+func (en *EnergyEstimation) readRAPLPower() (float64, error) {
+	zones, _ := filepath.Glob(path.Join(en.SysfsRoot, "class/powercap/intel-rapl:*"))
+	now := time.Now()
+	deltaT := now.Sub(en.lastRAPLT).Seconds()
+	total := 0.
+	found := false
+	firstRead := false
+	for _, zone := range zones {
+		if !raplPackageZone.MatchString(path.Base(zone)) {
+			continue
+		}
+		energy, err := readFloatFromFile(path.Join(zone, "energy_uj"))
+		if err != nil {
+			return 0, err
+		}
+		found = true
+		current := uint64(energy)
+		last, seen := en.lastRAPL[zone]
+		en.lastRAPL[zone] = current
+		if !seen {
+			firstRead = true
+			continue
+		}
+		delta := current - last
+		if current < last {
+			// the counter wrapped around
+			maxRange, err := readFloatFromFile(path.Join(zone, "max_energy_range_uj"))
+			if err != nil {
+				return 0, err
+			}
+			delta = uint64(maxRange) - last + current
+		}
+		total += float64(delta) / 1e6
+	}
+	en.lastRAPLT = now
+	if !found {
+		return 0, fmt.Errorf("no RAPL package zone found")
+	}
+	if firstRead || deltaT <= 0 {
+		return 0, fmt.Errorf("RAPL energy is read for the first time")
+	}
+	return total / deltaT, nil
+}
+
+// ReadBoardPower returns the board power in watts
+func (en *EnergyEstimation) ReadBoardPower() (float64, error) {
+	if power, err := en.readJetsonPower(); err == nil {
+		return power, nil
+	}
+	return en.readRAPLPower()
+}
+
+// Observe keeps the latest CPU and GPU utilization of the plugin
+func (en *EnergyEstimation) Observe(e datatype.Event) {
+	v, ok := eventValue(e)
+	if !ok {
+		return
+	}
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	switch e.Type {
+	case datatype.EventPluginPerfCPU:
+		en.cpuPerc = v
+	case datatype.EventPluginPerfGPU:
+		en.gpuLoad = v
+		en.gpuSeen = true
+	}
+}
+
+// share returns the plugin's share of the board. It is the plugin's share of all CPU cores,
+// averaged with its GPU load when GPU utilization is measured
+func (en *EnergyEstimation) share() float64 {
+	cpuShare := math.Min(math.Max(en.cpuPerc/(100.*float64(en.numCPU)), 0), 1)
+	if !en.gpuSeen {
+		return cpuShare
+	}
+	gpuShare := math.Min(math.Max(en.gpuLoad/100., 0), 1)
+	return (cpuShare + gpuShare) / 2.
+}
+
+// update adds energy consumed by the plugin over the elapsed seconds
+func (en *EnergyEstimation) update(boardPower float64, elapsed float64) float64 {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	en.pluginPower = boardPower * en.share()
+	en.energyJoules += en.pluginPower * elapsed
+	return en.energyJoules
+}
+
+func (en *EnergyEstimation) Describe(ch chan<- *prometheus.Desc) {
+	ch <- en.promEnergy
+	ch <- en.promPower
+}
+
+func (en *EnergyEstimation) Collect(ch chan<- prometheus.Metric) {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(en.promEnergy, prometheus.CounterValue, en.energyJoules)
+	ch <- prometheus.MustNewConstMetric(en.promPower, prometheus.GaugeValue, en.pluginPower)
+}
+
+func (en *EnergyEstimation) Stop() {
+	en.quit <- struct{}{}
+}
+
+// setInterval changes the sampling interval. It takes effect on the next Run
+func (en *EnergyEstimation) setInterval(seconds int) {
+	en.interval = seconds
+}
+
+func (en *EnergyEstimation) Run() {
+	ticker := time.NewTicker(time.Duration(en.interval) * time.Second)
+	lastT := time.Now()
+	for {
+		select {
+		case t := <-ticker.C:
+			elapsed := t.Sub(lastT).Seconds()
+			lastT = t
+			start := time.Now()
+			power, err := en.ReadBoardPower()
+			observeCollection("energy", start, err)
+			if err == nil {
+				e := datatype.NewEventBuilder(EventPluginPerfEnergy).
+					AddValue(en.update(power, elapsed)).
+					Build()
+				en.Notifier.Notify(e)
+			} else {
+				en.log.Error("failed to read board power", "error", err)
+			}
+		case <-en.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
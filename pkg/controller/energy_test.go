@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func writeTestSysfsFile(t *testing.T, filePath string, content string) {
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadJetsonPower(t *testing.T) {
+	root := t.TempDir()
+	iio := path.Join(root, "bus/i2c/drivers/ina3221x/7-0040/iio:device0")
+	writeTestSysfsFile(t, path.Join(iio, "rail_name_0"), "VDD_IN\n")
+	writeTestSysfsFile(t, path.Join(iio, "in_power0_input"), "5200\n")
+	writeTestSysfsFile(t, path.Join(iio, "rail_name_1"), "VDD_CPU_GPU_CV\n")
+	writeTestSysfsFile(t, path.Join(iio, "in_power1_input"), "1800\n")
+	en := NewEnergyEstimation(ControllerConfig{SysfsRoot: root})
+	power, err := en.ReadBoardPower()
+	assert.NilError(t, err)
+	// rails are part of the board input
+	assert.Equal(t, power, 5.2)
+
+	root = t.TempDir()
+	hwmon := path.Join(root, "bus/i2c/drivers/ina3221/1-0040/hwmon/hwmon3")
+	writeTestSysfsFile(t, path.Join(hwmon, "in1_label"), "VDD_GPU_SOC\n")
+	writeTestSysfsFile(t, path.Join(hwmon, "in1_input"), "5000\n")
+	writeTestSysfsFile(t, path.Join(hwmon, "curr1_input"), "400\n")
+	writeTestSysfsFile(t, path.Join(hwmon, "in2_label"), "VDD_CPU_CV\n")
+	writeTestSysfsFile(t, path.Join(hwmon, "in2_input"), "5000\n")
+	writeTestSysfsFile(t, path.Join(hwmon, "curr2_input"), "200\n")
+	en = NewEnergyEstimation(ControllerConfig{SysfsRoot: root})
+	power, err = en.ReadBoardPower()
+	assert.NilError(t, err)
+	assert.Equal(t, power, 3.)
+}
+
+func TestReadRAPLPower(t *testing.T) {
+	root := t.TempDir()
+	zone := path.Join(root, "class/powercap/intel-rapl:0")
+	writeTestSysfsFile(t, path.Join(zone, "energy_uj"), "1000000\n")
+	writeTestSysfsFile(t, path.Join(zone, "max_energy_range_uj"), "262143328850\n")
+	// sub-zones are part of the package
+	writeTestSysfsFile(t, path.Join(root, "class/powercap/intel-rapl:0:0/energy_uj"), "500000\n")
+	en := NewEnergyEstimation(ControllerConfig{SysfsRoot: root})
+	_, err := en.ReadBoardPower()
+	assert.ErrorContains(t, err, "first time")
+
+	en.lastRAPLT = time.Now().Add(-2 * time.Second)
+	writeTestSysfsFile(t, path.Join(zone, "energy_uj"), "21000000\n")
+	power, err := en.ReadBoardPower()
+	assert.NilError(t, err)
+	// 20 joules over 2 seconds
+	assert.Assert(t, power > 9.9 && power < 10.1)
+}
+
+func TestEnergyAttribution(t *testing.T) {
+	en := NewEnergyEstimation(ControllerConfig{})
+	en.numCPU = 4
+	en.Observe(datatype.NewEventBuilder(datatype.EventPluginPerfCPU).AddValue(200.).Build())
+	// half of the CPU cores for 10 seconds at 10 watts
+	assert.Equal(t, en.update(10, 10), 50.)
+
+	en.Observe(datatype.NewEventBuilder(datatype.EventPluginPerfGPU).AddValue(100.).Build())
+	// average of the CPU share and the GPU load
+	assert.Equal(t, en.update(10, 10), 125.)
+}
+
+func TestEnergyEstimationSamplesCPU(t *testing.T) {
+	// the estimate attributes power by CPU utilization, so CPU is sampled without CPU performance logging
+	c := ControllerConfig{EnableEnergyEstimation: true}
+	assert.Assert(t, needsCPUCollector(c))
+	assert.Assert(t, needsCPUSamples(c))
+	c = ControllerConfig{EnableCPUPerformanceLogging: true}
+	assert.Assert(t, needsCPUCollector(c))
+	assert.Assert(t, !needsCPUSamples(c))
+}
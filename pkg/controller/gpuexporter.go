@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/cenkalti/backoff.v1"
+)
+
+const (
+	// gpuScrapeFailureThreshold is the number of consecutive failures that opens the circuit
+	gpuScrapeFailureThreshold = 3
+)
+
+// ExporterGPUSource scrapes GPU load from the wes-jetson-exporter. Each scrape is bound by
+// a timeout. After consecutive failures, the circuit opens and the exporter is not scraped
+// until the backoff elapses. Then one scrape is let through and the circuit closes if it succeeds
+type ExporterGPUSource struct {
+	Host        string
+	Port        int
+	scheme      string
+	bearerToken string
+	client      *http.Client
+
+	mu           sync.Mutex
+	failures     int
+	openUntil    time.Time
+	circuitDelay backoff.BackOff
+
+	promScrapeErrors   prometheus.Counter
+	promScrapeDuration prometheus.Histogram
+	promLastSuccess    prometheus.Gauge
+}
+
+func NewExporterGPUSource(c ControllerConfig) (*ExporterGPUSource, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.GPUMetricTLS {
+		scheme = "https"
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: c.GPUMetricInsecureSkipVerify,
+		}
+		if c.GPUMetricCACert != "" {
+			caCert, err := os.ReadFile(c.GPUMetricCACert)
+			if err != nil {
+				return nil, err
+			}
+			rootCAs := x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("failed to load CA certificate from %s", c.GPUMetricCACert)
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	timeout := time.Duration(c.GPUMetricTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	port := c.GPUMetricPort
+	if port == 0 {
+		port = 9101
+	}
+	circuitDelay := backoff.NewExponentialBackOff()
+	circuitDelay.InitialInterval = 5 * time.Second
+	circuitDelay.MaxInterval = 5 * time.Minute
+	circuitDelay.MaxElapsedTime = 0
+	return &ExporterGPUSource{
+		Host:        c.GPUMetricHost,
+		Port:        port,
+		scheme:      scheme,
+		bearerToken: c.GPUMetricBearerToken,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		circuitDelay: circuitDelay,
+
+		promScrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "plugin_gpu_scrape_errors_total",
+			Help: "Number of failed scrapes of the GPU metric exporter",
+		}),
+		promScrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "plugin_gpu_scrape_duration_seconds",
+			Help:    "Duration of scrapes of the GPU metric exporter in seconds",
+			Buckets: prometheus.DefBuckets,
+		}),
+		promLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "plugin_gpu_last_success_timestamp",
+			Help: "Unix timestamp of the last successful scrape of the GPU metric exporter",
+		}),
+	}, nil
+}
+
+func (e *ExporterGPUSource) Name() string {
+	return GPUBackendExporter
+}
+
+func (e *ExporterGPUSource) Describe(ch chan<- *prometheus.Desc) {
+	e.promScrapeErrors.Describe(ch)
+	e.promScrapeDuration.Describe(ch)
+	e.promLastSuccess.Describe(ch)
+}
+
+func (e *ExporterGPUSource) Collect(ch chan<- prometheus.Metric) {
+	e.promScrapeErrors.Collect(ch)
+	e.promScrapeDuration.Collect(ch)
+	e.promLastSuccess.Collect(ch)
+}
+
+func (e *ExporterGPUSource) Read() (GPUMetric, error) {
+	m := newGPUMetric()
+	e.mu.Lock()
+	if time.Now().Before(e.openUntil) {
+		e.mu.Unlock()
+		return m, fmt.Errorf("GPU metric exporter at %s is backed off until %s", e.Host, e.openUntil.Format(time.RFC3339))
+	}
+	e.mu.Unlock()
+	start := time.Now()
+	u, err := e.getGPUMetric()
+	e.promScrapeDuration.Observe(time.Since(start).Seconds())
+	e.recordResult(err)
+	if err != nil {
+		return m, err
+	}
+	m.Utilization = u
+	return m, nil
+}
+
+// recordResult updates the circuit with the result of a scrape
+func (e *ExporterGPUSource) recordResult(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		e.failures = 0
+		e.circuitDelay.Reset()
+		e.promLastSuccess.SetToCurrentTime()
+		return
+	}
+	e.promScrapeErrors.Inc()
+	e.failures += 1
+	if e.failures >= gpuScrapeFailureThreshold {
+		e.openUntil = time.Now().Add(e.circuitDelay.NextBackOff())
+	}
+}
+
+// getGPUMetric returns GPU load in percent
+func (e *ExporterGPUSource) getGPUMetric() (float64, error) {
+	s, err := url.JoinPath(fmt.Sprintf("%s://%s:%d", e.scheme, e.Host, e.Port), "metrics")
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodGet, s, nil)
+	if err != nil {
+		return 0, err
+	}
+	if e.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.bearerToken)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GPU metric exporter responded %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	re := regexp.MustCompile(`gpu_average_load1s [0-9.]+`)
+	matches := re.FindStringSubmatch(string(body[:]))
+	if len(matches) != 1 {
+		return 0, fmt.Errorf("failed to get gpu_average_load1s value from %s", body[:])
+	}
+	sp := strings.Split(matches[0], " ")
+	if len(sp) != 2 {
+		return 0, fmt.Errorf("failed to split value from %s", matches[0])
+	}
+	gpuUtil, err := strconv.ParseFloat(sp[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	// gpuUtil reported from wes-jetson-exporter ranges from [0., 1.]
+	return gpuUtil * 100., nil
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/v3/assert"
+)
+
+func TestExporterGPUSourceCircuit(t *testing.T) {
+	healthy := true
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "gpu_average_load1s 0.25")
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	e, err := NewExporterGPUSource(ControllerConfig{
+		GPUMetricHost:        host,
+		GPUMetricPort:        p,
+		GPUMetricBearerToken: "secret",
+	})
+	assert.NilError(t, err)
+	m, err := e.Read()
+	assert.NilError(t, err)
+	assert.Equal(t, m.Utilization, 25.)
+
+	healthy = false
+	for i := 0; i < gpuScrapeFailureThreshold; i++ {
+		_, err = e.Read()
+		assert.ErrorContains(t, err, "500")
+	}
+	// the circuit is open and the exporter is not scraped
+	_, err = e.Read()
+	assert.ErrorContains(t, err, "backed off")
+	assert.Equal(t, requests, 1+gpuScrapeFailureThreshold)
+	assert.Equal(t, testutil.ToFloat64(e.promScrapeErrors), float64(gpuScrapeFailureThreshold))
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// jetsonGPUDevices are sysfs directories of the integrated GPU on Jetson modules
+var jetsonGPUDevices = []string{
+	"devices/gpu.0",
+	"devices/platform/gpu.0",
+	"devices/17000000.ga10b",
+	"devices/17000000.gv11b",
+	"devices/57000000.gpu",
+}
+
+// jetsonGPUThermalZones are names of the thermal zone that measures the GPU temperature
+var jetsonGPUThermalZones = map[string]bool{
+	"GPU-therm":   true,
+	"gpu-thermal": true,
+}
+
+// JetsonGPUSource reads load, frequency and temperature of the Jetson GPU from sysfs.
+// Jetson GPUs share the system memory, so GPU memory is not reported
+type JetsonGPUSource struct {
+	SysfsRoot string
+}
+
+func NewJetsonGPUSource(c ControllerConfig) *JetsonGPUSource {
+	return &JetsonGPUSource{
+		SysfsRoot: c.SysfsRoot,
+	}
+}
+
+func (j *JetsonGPUSource) Name() string {
+	return GPUBackendJetson
+}
+
+func (j *JetsonGPUSource) Read() (GPUMetric, error) {
+	m := newGPUMetric()
+	load, err := readJetsonGPULoad(j.SysfsRoot)
+	if err != nil {
+		return m, err
+	}
+	m.Utilization = load
+	if freq, err := readJetsonGPUFrequency(j.SysfsRoot); err == nil {
+		m.FrequencyHz = freq
+	}
+	if temp, err := readJetsonGPUTemperature(j.SysfsRoot); err == nil {
+		m.TemperatureCelsius = temp
+	}
+	return m, nil
+}
+
+// findJetsonGPUDevice returns the sysfs directory of the Jetson GPU that reports its load
+func findJetsonGPUDevice(sysfsRoot string) (string, error) {
+	for _, device := range jetsonGPUDevices {
+		p := path.Join(sysfsRoot, device)
+		if _, err := readFloatFromFile(path.Join(p, "load")); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no Jetson GPU found under %s", sysfsRoot)
+}
+
+// readJetsonGPULoad returns the GPU load in percent. The load file reports the load
+// in per-mille
+func readJetsonGPULoad(sysfsRoot string) (float64, error) {
+	device, err := findJetsonGPUDevice(sysfsRoot)
+	if err != nil {
+		return 0, err
+	}
+	load, err := readFloatFromFile(path.Join(device, "load"))
+	if err != nil {
+		return 0, err
+	}
+	return load / 10., nil
+}
+
+// readJetsonGPUFrequency returns the current GPU frequency in Hz from the GPU's devfreq node
+func readJetsonGPUFrequency(sysfsRoot string) (float64, error) {
+	var candidates []string
+	if device, err := findJetsonGPUDevice(sysfsRoot); err == nil {
+		candidates, _ = filepath.Glob(path.Join(device, "devfreq/*/cur_freq"))
+	}
+	for _, device := range jetsonGPUDevices {
+		matches, _ := filepath.Glob(path.Join(sysfsRoot, "class/devfreq", path.Base(device), "cur_freq"))
+		candidates = append(candidates, matches...)
+	}
+	for _, freqFile := range candidates {
+		if freq, err := readFloatFromFile(freqFile); err == nil {
+			return freq, nil
+		}
+	}
+	return 0, fmt.Errorf("no devfreq node of Jetson GPU found under %s", sysfsRoot)
+}
+
+// readJetsonGPUTemperature returns the GPU temperature in Celsius. Thermal zones report
+// the temperature in millidegree Celsius
+func readJetsonGPUTemperature(sysfsRoot string) (float64, error) {
+	zones, _ := filepath.Glob(path.Join(sysfsRoot, "class/thermal/thermal_zone*"))
+	for _, zone := range zones {
+		zoneType, err := os.ReadFile(path.Join(zone, "type"))
+		if err != nil || !jetsonGPUThermalZones[strings.TrimSpace(string(zoneType))] {
+			continue
+		}
+		temp, err := readFloatFromFile(path.Join(zone, "temp"))
+		if err != nil {
+			return 0, err
+		}
+		return temp / 1000., nil
+	}
+	return 0, fmt.Errorf("no GPU thermal zone found under %s", sysfsRoot)
+}
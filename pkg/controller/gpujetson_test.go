@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"math"
+	"path"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestReadJetsonGPU(t *testing.T) {
+	root := t.TempDir()
+	_, err := readJetsonGPULoad(root)
+	assert.ErrorContains(t, err, "no Jetson GPU found")
+
+	writeTestSysfsFile(t, path.Join(root, "devices/gpu.0/load"), "455\n")
+	writeTestSysfsFile(t, path.Join(root, "devices/gpu.0/devfreq/57000000.gpu/cur_freq"), "1300500000\n")
+	load, err := readJetsonGPULoad(root)
+	assert.NilError(t, err)
+	assert.Equal(t, load, 45.5)
+	freq, err := readJetsonGPUFrequency(root)
+	assert.NilError(t, err)
+	assert.Equal(t, freq, 1300500000.)
+
+	writeTestSysfsFile(t, path.Join(root, "class/thermal/thermal_zone1/type"), "GPU-therm\n")
+	writeTestSysfsFile(t, path.Join(root, "class/thermal/thermal_zone1/temp"), "41500\n")
+	// the exporter is not deployed, so auto backend reads sysfs
+	source, err := NewGPUSource(ControllerConfig{SysfsRoot: root, NvidiaSMIPath: path.Join(root, "missing")}, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Name(), GPUBackendJetson)
+	m, err := source.Read()
+	assert.NilError(t, err)
+	assert.Equal(t, m.Utilization, 45.5)
+	assert.Equal(t, m.FrequencyHz, 1300500000.)
+	assert.Equal(t, m.TemperatureCelsius, 41.5)
+	assert.Assert(t, math.IsNaN(m.MemoryUsedBytes))
+}
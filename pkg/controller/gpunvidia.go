@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nvidiaSMITimeout = 10 * time.Second
+
+// NvidiaSMI reads per-process GPU usage by running nvidia-smi
+type NvidiaSMI struct {
+	Path string
+}
+
+func NewNvidiaSMI(binaryPath string) *NvidiaSMI {
+	if binaryPath == "" {
+		binaryPath = "nvidia-smi"
+	}
+	return &NvidiaSMI{
+		Path: binaryPath,
+	}
+}
+
+func (n *NvidiaSMI) run(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nvidiaSMITimeout)
+	defer cancel()
+	out, err := helperOutput(exec.CommandContext(ctx, n.Path, args...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s %s: %s", n.Path, strings.Join(args, " "), err.Error())
+	}
+	return out, nil
+}
+
+// queryComputeApps returns GPU memory used by each process in bytes
+func (n *NvidiaSMI) queryComputeApps() (map[int32]float64, error) {
+	out, err := n.run("--query-compute-apps=pid,used_memory", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	used := map[int32]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 32)
+		if err != nil {
+			continue
+		}
+		mebiBytes, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			continue
+		}
+		// a process may use more than one GPU
+		used[int32(pid)] += mebiBytes * 1024 * 1024
+	}
+	return used, nil
+}
+
+// pmon returns SM utilization of each process in percent. The columns of pmon
+// differ between driver versions, so the sm column is found from the header
+func (n *NvidiaSMI) pmon() (map[int32]float64, error) {
+	out, err := n.run("pmon", "-c", "1", "-s", "u")
+	if err != nil {
+		return nil, err
+	}
+	pidColumn, smColumn := -1, -1
+	util := map[int32]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			if pidColumn >= 0 {
+				continue
+			}
+			for i, name := range strings.Fields(strings.TrimPrefix(line, "#")) {
+				switch name {
+				case "pid":
+					pidColumn = i
+				case "sm":
+					smColumn = i
+				}
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if pidColumn < 0 || smColumn < 0 || len(fields) <= smColumn || len(fields) <= pidColumn {
+			continue
+		}
+		pid, err := strconv.ParseInt(fields[pidColumn], 10, 32)
+		if err != nil {
+			continue
+		}
+		// "-" means no sample in the period
+		sm, err := strconv.ParseFloat(fields[smColumn], 64)
+		if err != nil {
+			continue
+		}
+		util[int32(pid)] += sm
+	}
+	if pidColumn < 0 || smColumn < 0 {
+		return nil, fmt.Errorf("failed to find pid and sm columns from %q", out)
+	}
+	return util, nil
+}
+
+// ReadProcessGPU returns the sum of SM utilization in percent and GPU memory used in bytes
+// of the given processes
+func (n *NvidiaSMI) ReadProcessGPU(pids []int32) (float64, float64, error) {
+	util, used, err := n.readProcesses()
+	if err != nil {
+		return 0, 0, err
+	}
+	smUtil, memory := sumProcessGPU(util, used, pids)
+	return smUtil, memory, nil
+}
+
+// readProcesses returns SM utilization in percent and GPU memory used in bytes
+// of every process using the GPUs
+func (n *NvidiaSMI) readProcesses() (map[int32]float64, map[int32]float64, error) {
+	used, err := n.queryComputeApps()
+	if err != nil {
+		return nil, nil, err
+	}
+	util, err := n.pmon()
+	if err != nil {
+		return nil, nil, err
+	}
+	return util, used, nil
+}
+
+func sumProcessGPU(util map[int32]float64, used map[int32]float64, pids []int32) (float64, float64) {
+	smUtil, memory := 0., 0.
+	for _, pid := range pids {
+		smUtil += util[pid]
+		memory += used[pid]
+	}
+	return smUtil, memory
+}
+
+// queryGPUs returns total memory in bytes, the highest SM clock in Hz and the highest
+// temperature in Celsius among the GPUs
+func (n *NvidiaSMI) queryGPUs() (float64, float64, float64, error) {
+	out, err := n.run("--query-gpu=memory.total,clocks.sm,temperature.gpu", "--format=csv,noheader,nounits")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	memoryTotal, clock, temperature := 0., 0., 0.
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 3 {
+			continue
+		}
+		values := make([]float64, len(fields))
+		for i, f := range fields {
+			values[i], _ = strconv.ParseFloat(strings.TrimSpace(f), 64)
+		}
+		memoryTotal += values[0] * 1024 * 1024
+		clock = math.Max(clock, values[1]*1e6)
+		temperature = math.Max(temperature, values[2])
+	}
+	return memoryTotal, clock, temperature, nil
+}
+
+// NvidiaGPUSource reports SM utilization and GPU memory used by the plugin's process tree.
+// Total memory, frequency and temperature are of the GPUs. nvidia-smi reports PIDs of the
+// host, so PIDs of the plugin are mapped to the host's through HostProcDir
+type NvidiaGPUSource struct {
+	ProcDir     string
+	HostProcDir string
+	PID         int32
+	smi         *NvidiaSMI
+}
+
+func NewNvidiaGPUSource(c ControllerConfig, pid int32) *NvidiaGPUSource {
+	procDir := c.ProcfsRoot
+	if procDir == "" {
+		procDir = "/proc"
+	}
+	return &NvidiaGPUSource{
+		ProcDir:     procDir,
+		HostProcDir: c.HostProcfsRoot,
+		PID:         pid,
+		smi:         NewNvidiaSMI(c.NvidiaSMIPath),
+	}
+}
+
+func (n *NvidiaGPUSource) Name() string {
+	return GPUBackendNvidia
+}
+
+func (n *NvidiaGPUSource) Read() (GPUMetric, error) {
+	m := newGPUMetric()
+	pids, err := listProcessTree(n.ProcDir, n.PID)
+	if err != nil {
+		return m, fmt.Errorf("failed to list plugin processes: %s", err.Error())
+	}
+	if pids, err = hostPIDs(n.ProcDir, n.HostProcDir, pids); err != nil {
+		return m, fmt.Errorf("failed to map plugin processes to the host: %s", err.Error())
+	}
+	smUtil, memory, err := n.smi.ReadProcessGPU(pids)
+	if err != nil {
+		return m, err
+	}
+	m.Utilization = smUtil
+	m.MemoryUsedBytes = memory
+	if memoryTotal, clock, temperature, err := n.smi.queryGPUs(); err == nil {
+		m.MemoryTotalBytes = memoryTotal
+		m.FrequencyHz = clock
+		m.TemperatureCelsius = temperature
+	}
+	return m, nil
+}
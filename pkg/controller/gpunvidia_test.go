@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// testNvidiaSMI stands in for nvidia-smi, which reports PIDs of the host. Process 4010
+// and 4020 are the plugin and process 4030 belongs to another plugin sharing the GPU
+const testNvidiaSMI = `#!/bin/sh
+case "$1" in
+--query-compute-apps=*)
+  echo "4010, 1024"
+  echo "4030, 2048"
+  ;;
+--query-gpu=*)
+  echo "16384, 1410, 52"
+  ;;
+pmon)
+  echo "# gpu        pid  type    sm   mem   enc   dec   jpg   ofa   command"
+  echo "# Idx          #   C/G     %     %     %     %     %     %   name"
+  echo "    0       4010     C    35    12     -     -     -     -   python3"
+  echo "    0       4020     C     -     -     -     -     -     -   ffmpeg"
+  echo "    0       4030     C    50    20     -     -     -     -   python3"
+  ;;
+*)
+  exit 1
+  ;;
+esac
+`
+
+func TestNvidiaSMIReadProcessGPU(t *testing.T) {
+	stub := path.Join(t.TempDir(), "nvidia-smi")
+	if err := os.WriteFile(stub, []byte(testNvidiaSMI), 0755); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNvidiaSMI(stub)
+	smUtil, memory, err := n.ReadProcessGPU([]int32{4010, 4020})
+	assert.NilError(t, err)
+	assert.Equal(t, smUtil, 35.)
+	assert.Equal(t, memory, 1024.*1024*1024)
+
+	n = NewNvidiaSMI(path.Join(t.TempDir(), "missing"))
+	_, _, err = n.ReadProcessGPU([]int32{4010})
+	assert.ErrorContains(t, err, "failed to run")
+}
+
+// writeTestHostProcess writes the stat and status of a process as the host sees it.
+// The process is nsPID in its own PID namespace
+func writeTestHostProcess(t *testing.T, hostProcDir string, hostPID int, nsPID int, startTime int) {
+	dir := path.Join(hostProcDir, fmt.Sprint(hostPID))
+	writeTestSysfsFile(t, path.Join(dir, "stat"), fmt.Sprintf("%d (python3) S 1 %d %d 0 -1 4194560 1000 0 0 0 0 0 0 0 20 0 1 0 %d 0 0", hostPID, hostPID, hostPID, startTime))
+	writeTestSysfsFile(t, path.Join(dir, "status"), fmt.Sprintf("Name:\tpython3\nTgid:\t%d\nNSpid:\t%d\t%d\n", hostPID, hostPID, nsPID))
+}
+
+func TestNvidiaGPUSource(t *testing.T) {
+	procDir := t.TempDir()
+	writeTestTask(t, procDir, 10, 1, 10, "python3", 0, 0)
+	writeTestTask(t, procDir, 20, 10, 20, "ffmpeg", 0, 0)
+	stub := path.Join(t.TempDir(), "nvidia-smi")
+	if err := os.WriteFile(stub, []byte(testNvidiaSMI), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// processes of the plugin start at 100 in clock ticks since boot
+	hostProcDir := t.TempDir()
+	writeTestHostProcess(t, hostProcDir, 4010, 10, 100)
+	writeTestHostProcess(t, hostProcDir, 4020, 20, 100)
+	// a process of another container with the same PID in its namespace
+	writeTestHostProcess(t, hostProcDir, 4030, 10, 250)
+	source := NewNvidiaGPUSource(ControllerConfig{NvidiaSMIPath: stub, ProcfsRoot: procDir, HostProcfsRoot: hostProcDir}, 10)
+	m, err := source.Read()
+	assert.NilError(t, err)
+	assert.Equal(t, m.Utilization, 35.)
+	assert.Equal(t, m.MemoryUsedBytes, 1024.*1024*1024)
+	assert.Equal(t, m.MemoryTotalBytes, 16384.*1024*1024)
+	assert.Equal(t, m.FrequencyHz, 1410e6)
+	assert.Equal(t, m.TemperatureCelsius, 52.)
+}
+
+func TestHostPIDs(t *testing.T) {
+	procDir := t.TempDir()
+	writeTestTask(t, procDir, 10, 1, 10, "python3", 0, 0)
+	hostProcDir := t.TempDir()
+	writeTestHostProcess(t, hostProcDir, 4010, 10, 100)
+	writeTestHostProcess(t, hostProcDir, 4030, 10, 250)
+	// a process of the host itself has a single PID
+	writeTestSysfsFile(t, path.Join(hostProcDir, "10", "stat"), "10 (systemd-journal) S 1 10 10 0 -1 4194560 1000 0 0 0 0 0 0 0 20 0 1 0 100 0 0")
+	writeTestSysfsFile(t, path.Join(hostProcDir, "10", "status"), "Name:\tsystemd-journal\nNSpid:\t10\n")
+	pids, err := hostPIDs(procDir, hostProcDir, []int32{10, 11})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pids, []int32{4010})
+
+	// without a procfs of the host, the controller is in the host's PID namespace
+	pids, err = hostPIDs(procDir, "", []int32{10})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pids, []int32{10})
+}
@@ -1,84 +1,96 @@
 package controller
 
 import (
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"regexp"
-	"strconv"
-	"strings"
+	"log/slog"
+	"math"
 	"time"
 
 	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
 	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
-	"github.com/waggle-sensor/edge-scheduler/pkg/logger"
 )
 
+const (
+	EventPluginPerfGPUFrequency   datatype.EventType = "sys.plugin.perf.gpu.freq"
+	EventPluginPerfGPUMemory      datatype.EventType = "sys.plugin.perf.gpu.mem"
+	EventPluginPerfGPUMemoryTotal datatype.EventType = "sys.plugin.perf.gpu.mem.total"
+	EventPluginPerfGPUTemperature datatype.EventType = "sys.plugin.perf.gpu.temp"
+)
+
+const (
+	GPUBackendAuto     = "auto"
+	GPUBackendExporter = "exporter"
+	GPUBackendJetson   = "jetson"
+	GPUBackendNvidia   = "nvidia"
+	GPUBackendMock     = "mock"
+)
+
+// GPUPerformanceLogging periodically reads the GPU source and notifies
+// what the source measures
 type GPUPerformanceLogging struct {
-	GPUMetricHost string
-	Notifier      *interfacing.Notifier
-	quit          chan struct{}
-	interval      int
+	Source   GPUSource
+	Notifier *interfacing.Notifier
+	quit     chan struct{}
+	interval int
+	log      *slog.Logger
 }
 
-func NewGPUPerformanceLogging(c ControllerConfig) *GPUPerformanceLogging {
-	return &GPUPerformanceLogging{
-		GPUMetricHost: c.GPUMetricHost,
-		Notifier:      interfacing.NewNotifier(),
-		quit:          make(chan struct{}),
-		interval:      c.PerformanceCollectionInterval,
+func NewGPUPerformanceLogging(c ControllerConfig, pid int32) (*GPUPerformanceLogging, error) {
+	source, err := NewGPUSource(c, pid)
+	if err != nil {
+		return nil, err
 	}
+	return &GPUPerformanceLogging{
+		Source:   source,
+		Notifier: interfacing.NewNotifier(),
+		quit:     make(chan struct{}),
+		interval: c.PerformanceCollectionInterval,
+		log:      componentLogger("gpu").With("source", source.Name()),
+	}, nil
 }
 
-// getGPUMetric returns
-func (g *GPUPerformanceLogging) getGPUMetric() (float64, error) {
-	s, err := url.JoinPath(fmt.Sprintf("http://%s:9101", g.GPUMetricHost), "metrics")
-	if err != nil {
-		return 0, err
-	}
-	resp, err := http.Get(s)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
-	}
-	re := regexp.MustCompile(`gpu_average_load1s [0-9.]+`)
-	matches := re.FindStringSubmatch(string(body[:]))
-	if len(matches) != 1 {
-		return 0, fmt.Errorf("failed to get total_inactive_file value from %s", body[:])
-	}
-	sp := strings.Split(matches[0], " ")
-	if len(sp) != 2 {
-		return 0, fmt.Errorf("failed to split value from %s", matches[0])
-	}
-	gpuUtil, err := strconv.ParseFloat(sp[1], 64)
-	if err != nil {
-		return 0, err
+// notifyGPUMetric notifies an event for each field measured by the source
+func (g *GPUPerformanceLogging) notifyGPUMetric(m GPUMetric) {
+	for _, f := range []struct {
+		eventType datatype.EventType
+		value     float64
+	}{
+		{datatype.EventPluginPerfGPU, m.Utilization},
+		{EventPluginPerfGPUMemory, m.MemoryUsedBytes},
+		{EventPluginPerfGPUMemoryTotal, m.MemoryTotalBytes},
+		{EventPluginPerfGPUFrequency, m.FrequencyHz},
+		{EventPluginPerfGPUTemperature, m.TemperatureCelsius},
+	} {
+		if math.IsNaN(f.value) {
+			continue
+		}
+		e := datatype.NewEventBuilder(f.eventType).
+			AddValue(f.value).
+			Build()
+		g.Notifier.Notify(e)
 	}
-	// gpuUtil reported from wes-jetson-exporter ranges from [0., 1.]
-	return gpuUtil * 100., nil
 }
 
 func (g *GPUPerformanceLogging) Stop() {
 	g.quit <- struct{}{}
 }
 
+// setInterval changes the sampling interval. It takes effect on the next Run
+func (g *GPUPerformanceLogging) setInterval(seconds int) {
+	g.interval = seconds
+}
+
 func (g *GPUPerformanceLogging) Run() {
 	ticker := time.NewTicker(time.Duration(g.interval) * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			if u, err := g.getGPUMetric(); err == nil {
-				e := datatype.NewEventBuilder(datatype.EventPluginPerfGPU).
-					AddValue(u).
-					Build()
-				g.Notifier.Notify(e)
+			start := time.Now()
+			m, err := g.Source.Read()
+			observeCollection("gpu", start, err)
+			if err == nil {
+				g.notifyGPUMetric(m)
 			} else {
-				logger.Error.Println(err.Error())
+				g.log.Error("failed to read GPU metrics", "error", err)
 			}
 		case <-g.quit:
 			ticker.Stop()
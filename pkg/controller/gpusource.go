@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GPUMetric is a GPU reading common to all GPU sources. A field that the source
+// does not measure is NaN
+type GPUMetric struct {
+	Utilization        float64
+	MemoryUsedBytes    float64
+	MemoryTotalBytes   float64
+	FrequencyHz        float64
+	TemperatureCelsius float64
+}
+
+func newGPUMetric() GPUMetric {
+	return GPUMetric{
+		Utilization:        math.NaN(),
+		MemoryUsedBytes:    math.NaN(),
+		MemoryTotalBytes:   math.NaN(),
+		FrequencyHz:        math.NaN(),
+		TemperatureCelsius: math.NaN(),
+	}
+}
+
+// GPUSource reads GPU metrics from a backend
+type GPUSource interface {
+	Name() string
+	Read() (GPUMetric, error)
+}
+
+// NewGPUSource returns the GPU source of the configured backend. The auto backend
+// reads the wes-jetson-exporter if its host is given, with Jetson sysfs as the
+// fallback if the Jetson GPU is found. Otherwise it picks nvidia-smi if it is installed,
+// then Jetson sysfs, and scrapes the exporter on the local host as the last resort
+func NewGPUSource(c ControllerConfig, pid int32) (GPUSource, error) {
+	switch c.GPUBackend {
+	case GPUBackendExporter:
+		return NewExporterGPUSource(c)
+	case GPUBackendJetson:
+		return NewJetsonGPUSource(c), nil
+	case GPUBackendNvidia:
+		return NewNvidiaGPUSource(c, pid), nil
+	case GPUBackendMock:
+		return &MockGPUSource{Metric: GPUMetric{}}, nil
+	case GPUBackendAuto, "":
+		_, jetsonErr := findJetsonGPUDevice(c.SysfsRoot)
+		if c.GPUMetricHost != "" {
+			exporter, err := NewExporterGPUSource(c)
+			if err != nil {
+				return nil, err
+			}
+			if jetsonErr == nil {
+				return &FallbackGPUSource{Sources: []GPUSource{exporter, NewJetsonGPUSource(c)}}, nil
+			}
+			return exporter, nil
+		}
+		if _, err := exec.LookPath(NewNvidiaSMI(c.NvidiaSMIPath).Path); err == nil {
+			return NewNvidiaGPUSource(c, pid), nil
+		}
+		if jetsonErr == nil {
+			return NewJetsonGPUSource(c), nil
+		}
+		return NewExporterGPUSource(c)
+	default:
+		return nil, fmt.Errorf("unknown GPU backend %q", c.GPUBackend)
+	}
+}
+
+// FallbackGPUSource reads the first source that succeeds
+type FallbackGPUSource struct {
+	Sources []GPUSource
+}
+
+func (f *FallbackGPUSource) Name() string {
+	name := ""
+	for i, s := range f.Sources {
+		if i > 0 {
+			name += ","
+		}
+		name += s.Name()
+	}
+	return name
+}
+
+// Describe and Collect expose metrics of the sources that have them
+func (f *FallbackGPUSource) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range f.Sources {
+		if c, ok := s.(prometheus.Collector); ok {
+			c.Describe(ch)
+		}
+	}
+}
+
+func (f *FallbackGPUSource) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range f.Sources {
+		if c, ok := s.(prometheus.Collector); ok {
+			c.Collect(ch)
+		}
+	}
+}
+
+func (f *FallbackGPUSource) Read() (GPUMetric, error) {
+	var errs []error
+	for _, s := range f.Sources {
+		m, err := s.Read()
+		if err == nil {
+			return m, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %s", s.Name(), err.Error()))
+	}
+	return newGPUMetric(), errors.Join(errs...)
+}
+
+// MockGPUSource reports the given metric. The mock backend reports an idle GPU
+type MockGPUSource struct {
+	Metric GPUMetric
+	Err    error
+}
+
+func (m *MockGPUSource) Name() string {
+	return GPUBackendMock
+}
+
+func (m *MockGPUSource) Read() (GPUMetric, error) {
+	return m.Metric, m.Err
+}
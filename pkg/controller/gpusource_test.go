@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestFallbackGPUSource(t *testing.T) {
+	failing := &MockGPUSource{Err: fmt.Errorf("connection refused")}
+	working := &MockGPUSource{Metric: GPUMetric{Utilization: 30}}
+	source := &FallbackGPUSource{Sources: []GPUSource{failing, working}}
+	m, err := source.Read()
+	assert.NilError(t, err)
+	assert.Equal(t, m.Utilization, 30.)
+
+	source = &FallbackGPUSource{Sources: []GPUSource{failing}}
+	_, err = source.Read()
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestGPUPerformanceLoggingNotifiesMeasuredFields(t *testing.T) {
+	g, err := NewGPUPerformanceLogging(ControllerConfig{GPUBackend: GPUBackendMock}, 0)
+	assert.NilError(t, err)
+	ch := make(chan datatype.Event, 10)
+	g.Notifier.Subscribe(ch)
+	m := newGPUMetric()
+	m.Utilization = 20
+	m.TemperatureCelsius = 40
+	g.notifyGPUMetric(m)
+	assert.Equal(t, len(ch), 2)
+	assert.Equal(t, (<-ch).Type, datatype.EventPluginPerfGPU)
+	assert.Equal(t, (<-ch).Type, EventPluginPerfGPUTemperature)
+
+	_, err = NewGPUSource(ControllerConfig{GPUBackend: "unknown"}, 0)
+	assert.ErrorContains(t, err, "unknown GPU backend")
+}
+
+func TestNewGPUSourceAuto(t *testing.T) {
+	root := t.TempDir()
+	nvidiaSMI := path.Join(root, "nvidia-smi")
+	writeTestSysfsFile(t, nvidiaSMI, "#!/bin/sh\n")
+	assert.NilError(t, os.Chmod(nvidiaSMI, 0755))
+
+	// the exporter of the given host comes before nvidia-smi
+	source, err := NewGPUSource(ControllerConfig{SysfsRoot: root, NvidiaSMIPath: nvidiaSMI, GPUMetricHost: "10.31.81.1"}, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Name(), GPUBackendExporter)
+	assert.Equal(t, source.(*ExporterGPUSource).Host, "10.31.81.1")
+
+	source, err = NewGPUSource(ControllerConfig{SysfsRoot: root, NvidiaSMIPath: nvidiaSMI}, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Name(), GPUBackendNvidia)
+
+	// without any backend found, the exporter on the local host is scraped
+	source, err = NewGPUSource(ControllerConfig{SysfsRoot: root, NvidiaSMIPath: path.Join(root, "missing")}, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Name(), GPUBackendExporter)
+	assert.Equal(t, source.(*ExporterGPUSource).Host, "")
+
+	writeTestSysfsFile(t, path.Join(root, "devices/gpu.0/load"), "455\n")
+	source, err = NewGPUSource(ControllerConfig{SysfsRoot: root, NvidiaSMIPath: nvidiaSMI, GPUMetricHost: "10.31.81.1"}, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Name(), GPUBackendExporter+","+GPUBackendJetson)
+}
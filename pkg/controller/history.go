@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// historyEvent is how an event in the history is served over the API
+type historyEvent struct {
+	Type      datatype.EventType     `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Meta      map[string]interface{} `json:"meta"`
+}
+
+// EventHistory keeps the recent events in a ring buffer. It holds up to MaxEvents
+// events and, if MaxAge is not 0, drops events older than MaxAge
+type EventHistory struct {
+	MaxEvents int
+	MaxAge    time.Duration
+
+	mu     sync.Mutex
+	events []datatype.Event
+	next   int
+	full   bool
+}
+
+func NewEventHistory(c ControllerConfig) *EventHistory {
+	return &EventHistory{
+		MaxEvents: c.EventHistorySize,
+		MaxAge:    time.Duration(c.EventHistoryMaxAge) * time.Second,
+		events:    make([]datatype.Event, c.EventHistorySize),
+	}
+}
+
+// Add keeps the event, overwriting the oldest event when the history is full
+func (h *EventHistory) Add(e datatype.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxEvents <= 0 {
+		return
+	}
+	h.events[h.next] = e
+	h.next = (h.next + 1) % h.MaxEvents
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// ordered returns the events oldest first
+func (h *EventHistory) ordered() []datatype.Event {
+	if !h.full {
+		return h.events[:h.next]
+	}
+	return append(append([]datatype.Event{}, h.events[h.next:]...), h.events[:h.next]...)
+}
+
+// Query returns the events since the given time, oldest first. If types is not empty,
+// only events of those types are returned. If limit is greater than 0, only the latest
+// limit events are returned
+func (h *EventHistory) Query(now time.Time, since time.Time, types map[datatype.EventType]bool, limit int) []historyEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxAge > 0 && since.Before(now.Add(-h.MaxAge)) {
+		since = now.Add(-h.MaxAge)
+	}
+	found := []historyEvent{}
+	for _, e := range h.ordered() {
+		t := time.Unix(0, e.Timestamp)
+		if t.Before(since) {
+			continue
+		}
+		if len(types) > 0 && !types[e.Type] {
+			continue
+		}
+		found = append(found, historyEvent{Type: e.Type, Timestamp: t, Meta: e.Meta})
+	}
+	if limit > 0 && len(found) > limit {
+		found = found[len(found)-limit:]
+	}
+	return found
+}
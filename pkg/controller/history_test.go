@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventHistoryRingBuffer(t *testing.T) {
+	now := time.Now()
+	h := NewEventHistory(ControllerConfig{EventHistorySize: 3})
+	for i := 0; i < 5; i++ {
+		h.Add(newTestPerfEvent(datatype.EventPluginPerfCPU, now.Add(time.Duration(i)*time.Second), float64(i)))
+	}
+	events := h.Query(now, time.Time{}, nil, 0)
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[0].Meta["value"], 2.)
+	assert.Equal(t, events[2].Meta["value"], 4.)
+
+	events = h.Query(now, time.Time{}, nil, 1)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Meta["value"], 4.)
+}
+
+func TestEventHistoryQuery(t *testing.T) {
+	now := time.Now()
+	h := NewEventHistory(ControllerConfig{EventHistorySize: 10, EventHistoryMaxAge: 60})
+	h.Add(newTestPerfEvent(datatype.EventPluginPerfCPU, now.Add(-2*time.Minute), 1))
+	h.Add(newTestPerfEvent(datatype.EventPluginPerfCPU, now.Add(-30*time.Second), 2))
+	h.Add(newTestPerfEvent(datatype.EventPluginPerfMem, now.Add(-20*time.Second), 3))
+	h.Add(newTestPerfEvent(datatype.EventPluginPerfCPU, now.Add(-10*time.Second), 4))
+
+	// events older than the maximum age are not returned
+	assert.Equal(t, len(h.Query(now, time.Time{}, nil, 0)), 3)
+	assert.Equal(t, len(h.Query(now, now.Add(-25*time.Second), nil, 0)), 2)
+	events := h.Query(now, time.Time{}, map[datatype.EventType]bool{datatype.EventPluginPerfCPU: true}, 0)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Meta["value"], 2.)
+}
+
+func TestEventsAPI(t *testing.T) {
+	api := NewAPIServer(ControllerConfig{})
+	rec := httptest.NewRecorder()
+	api.handlerEvents(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+	assert.Equal(t, rec.Code, http.StatusServiceUnavailable)
+
+	api.history = NewEventHistory(ControllerConfig{EventHistorySize: 10})
+	api.history.Add(newTestPerfEvent(datatype.EventPluginPerfCPU, time.Now(), 1))
+	rec = httptest.NewRecorder()
+	api.handlerEvents(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events?since=1m&type=sys.plugin.perf.cpu,sys.plugin.perf.mem&limit=5", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, len(rec.Body.String()) > 0)
+
+	rec = httptest.NewRecorder()
+	api.handlerEvents(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events?since=yesterday", nil))
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// logLineQueueSize is the number of lines that wait to be matched against the rules
+const logLineQueueSize = 1000
+
+// grokPatterns are the grok patterns that log rules may use as %{NAME} or %{NAME:group}
+var grokPatterns = map[string]string{
+	"INT":        `[+-]?[0-9]+`,
+	"NUMBER":     `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?`,
+	"WORD":       `\b\w+\b`,
+	"NOTSPACE":   `\S+`,
+	"DATA":       `.*?`,
+	"GREEDYDATA": `.*`,
+}
+
+// measurementNamePattern is the form of Waggle measurement names, dot separated words
+var measurementNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// expandGrok replaces grok patterns in the pattern with regular expressions
+func expandGrok(pattern string) (string, error) {
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		re, known := grokPatterns[m[1]]
+		if !known {
+			err = fmt.Errorf("unknown grok pattern %q", m[1])
+			return ref
+		}
+		if m[2] == "" {
+			return "(?:" + re + ")"
+		}
+		return "(?P<" + m[2] + ">" + re + ")"
+	})
+	return expanded, err
+}
+
+// LogRule turns a log line of the plugin matching Pattern into a measurement named Name.
+// Pattern is a regular expression that may use grok patterns, e.g. detected %{INT:cars} cars.
+// Name, Value and values of Meta are templates of the capture groups, e.g. $1 or ${cars}.
+// Value is the first group by default, or 1 if the pattern has no group. Stream limits
+// the rule to stdout or stderr of the plugin
+type LogRule struct {
+	Name    string
+	Pattern string
+	Value   string
+	Meta    map[string]string
+	Stream  string
+}
+
+type logLine struct {
+	stream string
+	line   string
+}
+
+type compiledLogRule struct {
+	LogRule
+	re *regexp.Regexp
+}
+
+// parseLogValue returns the value as an integer or a float if it is a number
+func parseLogValue(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// LogExtractor notifies measurements extracted from log lines of the plugin. A measurement
+// is an event of the rule's name whose meta carries the rule's meta, so it is published
+// like other events. StdoutPath and StderrPath are files that the plugin's output is
+// written to, which the extractor follows. Lines can also be queued by the supervisor,
+// which must not be held up by the extractor
+type LogExtractor struct {
+	StdoutPath string
+	StderrPath string
+	Notifier   *interfacing.Notifier
+	rules      []compiledLogRule
+	lines      chan logLine
+	log        *slog.Logger
+}
+
+func NewLogExtractor(c ControllerConfig) (*LogExtractor, error) {
+	rules := make([]compiledLogRule, 0, len(c.LogRules))
+	for _, r := range c.LogRules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("log rule %q must have a name", r.Pattern)
+		}
+		switch r.Stream {
+		case "", LogStreamStdout, LogStreamStderr:
+		default:
+			return nil, fmt.Errorf("unknown stream %q of log rule %s", r.Stream, r.Name)
+		}
+		// names with templates are checked once expanded
+		if !strings.Contains(r.Name, "$") && !measurementNamePattern.MatchString(r.Name) {
+			return nil, fmt.Errorf("invalid name of log rule %q", r.Name)
+		}
+		pattern, err := expandGrok(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of log rule %s: %s", r.Name, err.Error())
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of log rule %s: %s", r.Name, err.Error())
+		}
+		if r.Value == "" && re.NumSubexp() > 0 {
+			r.Value = "$1"
+		}
+		rules = append(rules, compiledLogRule{LogRule: r, re: re})
+	}
+	return &LogExtractor{
+		StdoutPath: c.PluginStdoutPath,
+		StderrPath: c.PluginStderrPath,
+		Notifier:   interfacing.NewNotifier(),
+		rules:      rules,
+		lines:      make(chan logLine, logLineQueueSize),
+		log:        componentLogger("log_extractor"),
+	}, nil
+}
+
+// Extract returns the measurements of the line. A line may match more than one rule.
+// A match is dropped if its name is not a valid measurement name
+func (l *LogExtractor) Extract(stream string, line string) []datatype.Event {
+	var events []datatype.Event
+	for _, r := range l.rules {
+		if r.Stream != "" && r.Stream != stream {
+			continue
+		}
+		match := r.re.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+		expand := func(template string) string {
+			return string(r.re.ExpandString(nil, template, line, match))
+		}
+		name := expand(r.Name)
+		if !measurementNamePattern.MatchString(name) {
+			l.log.Debug("dropped line of invalid measurement name", "rule", r.Name, "name", name)
+			logLinesDropped.WithLabelValues("invalid_name").Inc()
+			continue
+		}
+		var value interface{} = int64(1)
+		if r.Value != "" {
+			value = parseLogValue(expand(r.Value))
+		}
+		meta := map[string]string{}
+		for k, v := range r.Meta {
+			meta[k] = expand(v)
+		}
+		events = append(events, datatype.NewEventBuilder(datatype.EventType(name)).
+			AddValue(value).
+			AddEntry(measurementMetaKey, meta).
+			Build())
+	}
+	return events
+}
+
+// ProcessLine notifies the measurements of the line
+func (l *LogExtractor) ProcessLine(stream string, line string) {
+	for _, e := range l.Extract(stream, line) {
+		l.Notifier.Notify(e)
+	}
+}
+
+// Queue queues the line to be processed. The line is dropped if the queue is full
+func (l *LogExtractor) Queue(stream string, line string) {
+	select {
+	case l.lines <- logLine{stream: stream, line: line}:
+	default:
+		logLinesDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// follow reads lines appended to the file from its end. The file is read again from
+// the start when it is truncated or replaced
+func (l *LogExtractor) follow(filePath string, stream string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			l.ProcessLine(stream, strings.TrimRight(partial+line, "\r\n"))
+			partial = ""
+			continue
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+		// an incomplete line waits for the rest
+		partial += line
+		time.Sleep(500 * time.Millisecond)
+		opened, openedErr := f.Stat()
+		current, currentErr := os.Stat(filePath)
+		if openedErr != nil || currentErr != nil {
+			continue
+		}
+		if !os.SameFile(opened, current) || current.Size() < offset {
+			l.log.Info("log file is rotated", "file", filePath)
+			next, err := os.Open(filePath)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f, offset, partial = next, 0, ""
+			reader.Reset(f)
+		}
+	}
+}
+
+// Run processes queued lines and follows the output files of the plugin
+func (l *LogExtractor) Run() {
+	go func() {
+		for q := range l.lines {
+			l.ProcessLine(q.stream, q.line)
+		}
+	}()
+	for stream, filePath := range map[string]string{LogStreamStdout: l.StdoutPath, LogStreamStderr: l.StderrPath} {
+		if filePath == "" {
+			continue
+		}
+		go func(stream string, filePath string) {
+			for {
+				if err := l.follow(filePath, stream); err != nil {
+					l.log.Error("failed to follow log file", "file", filePath, "stream", stream, "error", err)
+				}
+				time.Sleep(5 * time.Second)
+			}
+		}(stream, filePath)
+	}
+}
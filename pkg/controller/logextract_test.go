@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestExpandGrok(t *testing.T) {
+	pattern, err := expandGrok(`detected %{INT:count} %{WORD:label}s in %{NUMBER}s`)
+	assert.NilError(t, err)
+	assert.Equal(t, pattern, `detected (?P<count>[+-]?[0-9]+) (?P<label>\b\w+\b)s in (?:[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?)s`)
+	_, err = expandGrok(`%{IPV4:address}`)
+	assert.ErrorContains(t, err, "unknown grok pattern")
+}
+
+func TestLogExtractor(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.json")
+	writeTestSysfsFile(t, configPath, `{"LogRules": [
+		{"Name": "env.count.${label}", "Pattern": "detected %{INT:count} %{WORD:label}", "Value": "${count}", "Meta": {"camera": "left", "label": "${label}"}, "Stream": "stdout"},
+		{"Name": "env.inference.seconds", "Pattern": "inference took ([0-9.]+)s"},
+		{"Name": "plugin.error", "Pattern": "Traceback", "Stream": "stderr"}
+	]}`)
+	var c ControllerConfig
+	assert.NilError(t, LoadConfigFile(configPath, &c))
+	l, err := NewLogExtractor(c)
+	assert.NilError(t, err)
+
+	events := l.Extract(LogStreamStdout, "frame 12: detected 3 cars, inference took 0.25s")
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Type, datatype.EventType("env.count.cars"))
+	assert.Equal(t, events[0].Meta["value"], int64(3))
+	assert.DeepEqual(t, events[0].Meta[measurementMetaKey], map[string]string{"camera": "left", "label": "cars"})
+	assert.Equal(t, events[1].Type, datatype.EventType("env.inference.seconds"))
+	assert.Equal(t, events[1].Meta["value"], 0.25)
+
+	// the rule of cars is only for stdout
+	assert.Equal(t, len(l.Extract(LogStreamStderr, "detected 3 cars")), 0)
+	events = l.Extract(LogStreamStderr, "Traceback (most recent call last):")
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Meta["value"], int64(1))
+	assert.Equal(t, len(l.Extract(LogStreamStdout, "Traceback (most recent call last):")), 0)
+
+	// the meta of the measurement goes into the Waggle message
+	msg := Metadata{"plugin_name": "test-pipeline", "camera": "default"}.WaggleMessage(l.Extract(LogStreamStdout, "detected 2 persons")[0])
+	assert.Equal(t, msg.Name, "env.count.persons")
+	assert.Equal(t, msg.Value, int64(2))
+	assert.DeepEqual(t, msg.Meta, map[string]string{"plugin_name": "test-pipeline", "camera": "left", "label": "persons"})
+
+	for _, rule := range []LogRule{
+		{Pattern: "no name"},
+		{Name: "invalid", Pattern: "("},
+		{Name: "unknown", Pattern: "%{UNKNOWN}"},
+		{Name: "stream", Pattern: "x", Stream: "stdin"},
+		{Name: "../name", Pattern: "x"},
+	} {
+		_, err := NewLogExtractor(ControllerConfig{LogRules: []LogRule{rule}})
+		assert.Assert(t, err != nil, rule)
+	}
+}
+
+func TestLogExtractorInvalidName(t *testing.T) {
+	l, err := NewLogExtractor(ControllerConfig{
+		LogRules: []LogRule{{Name: "env.count.${label}", Pattern: "detected %{INT:count} %{NOTSPACE:label}", Value: "${count}"}},
+	})
+	assert.NilError(t, err)
+	dropped := testutil.ToFloat64(logLinesDropped.WithLabelValues("invalid_name"))
+	assert.Equal(t, len(l.Extract(LogStreamStdout, "detected 3 cars")), 1)
+	assert.Equal(t, len(l.Extract(LogStreamStdout, "detected 3 ../../cars")), 0)
+	assert.Equal(t, len(l.Extract(LogStreamStdout, "detected 3 cars/trucks")), 0)
+	assert.Equal(t, testutil.ToFloat64(logLinesDropped.WithLabelValues("invalid_name"))-dropped, 2.)
+}
+
+func TestLogExtractorFollow(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "stdout.log")
+	writeTestSysfsFile(t, logPath, "detected 1 cars\n")
+	l, err := NewLogExtractor(ControllerConfig{
+		PluginStdoutPath: logPath,
+		LogRules:         []LogRule{{Name: "env.count.car", Pattern: "detected ([0-9]+) cars"}},
+	})
+	assert.NilError(t, err)
+	ch := make(chan datatype.Event, 10)
+	l.Notifier.Subscribe(ch)
+	l.Run()
+	// gives the extractor time to open the file
+	time.Sleep(200 * time.Millisecond)
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NilError(t, err)
+	f.WriteString("detected 2 ")
+	time.Sleep(600 * time.Millisecond)
+	f.WriteString("cars\n")
+	f.Close()
+	select {
+	case e := <-ch:
+		// lines written before the extractor starts are not read
+		assert.Equal(t, e.Meta["value"], int64(2))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no measurement from the appended line")
+	}
+
+	// the file is truncated by log rotation
+	assert.NilError(t, os.WriteFile(logPath, []byte("detected 3 cars\n"), 0644))
+	select {
+	case e := <-ch:
+		assert.Equal(t, e.Meta["value"], int64(3))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no measurement after rotation")
+	}
+}
+
+func TestLogExtractorQueue(t *testing.T) {
+	l, err := NewLogExtractor(ControllerConfig{
+		LogRules: []LogRule{{Name: "env.count.car", Pattern: "detected ([0-9]+) cars"}},
+	})
+	assert.NilError(t, err)
+	ch := make(chan datatype.Event)
+	l.Notifier.Subscribe(ch)
+	dropped := testutil.ToFloat64(logLinesDropped.WithLabelValues("queue_full"))
+	// nothing reads the events yet, so lines pile up in the queue without blocking
+	for i := 0; i < logLineQueueSize+5; i++ {
+		l.Queue(LogStreamStdout, "detected 1 cars")
+	}
+	assert.Equal(t, testutil.ToFloat64(logLinesDropped.WithLabelValues("queue_full"))-dropped, 5.)
+	l.Run()
+	for i := 0; i < logLineQueueSize; i++ {
+		e := <-ch
+		assert.Equal(t, e.Meta["value"], int64(1))
+	}
+}
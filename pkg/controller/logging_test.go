@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := NewLogger(&buf, LogFormatJSON, "warn")
+	assert.NilError(t, err)
+	log.Info("dropped")
+	log.Warn("kept", "component", "test")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 1)
+	var record map[string]interface{}
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, record["msg"], "kept")
+	assert.Equal(t, record["level"], "WARN")
+	assert.Equal(t, record["component"], "test")
+
+	_, err = NewLogger(&buf, "xml", "info")
+	assert.ErrorContains(t, err, "unknown log format")
+	_, err = NewLogger(&buf, LogFormatText, "verbose")
+	assert.ErrorContains(t, err, "unknown log level")
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := NewLogger(&buf, LogFormatJSON, "info")
+	assert.NilError(t, err)
+	h := accessLog(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("tea"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	var record map[string]interface{}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, record["method"], http.MethodGet)
+	assert.Equal(t, record["path"], "/api/v1/status")
+	assert.Equal(t, record["status"], float64(http.StatusTeapot))
+	assert.Equal(t, record["bytes"], 3.)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.json")
+	assert.NilError(t, os.WriteFile(configPath, []byte(`{"LogLevel": "debug", "LogFormat": "json"}`), 0644))
+	c := ControllerConfig{LogLevel: "info", PluginProcessName: "app"}
+	assert.NilError(t, LoadConfigFile(configPath, &c))
+	assert.Equal(t, c.LogLevel, "debug")
+	assert.Equal(t, c.LogFormat, LogFormatJSON)
+	assert.Equal(t, c.PluginProcessName, "app")
+
+	assert.NilError(t, os.WriteFile(configPath, []byte(`{"NoSuchField": true}`), 0644))
+	assert.ErrorContains(t, LoadConfigFile(configPath, &c), "unknown field")
+}
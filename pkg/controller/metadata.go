@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// metadataFromEnv maps environment variables of the controller and the plugin to metadata
+var metadataFromEnv = map[string]string{
+	"WAGGLE_APP_ID":      "app_id",
+	"WAGGLE_PLUGIN_NAME": "plugin_name",
+	"WAGGLE_PLUGIN_TASK": "plugin_task",
+	"WAGGLE_PLUGIN_JOB":  "plugin_job",
+	"WAGGLE_NODE_ID":     "node",
+	"WAGGLE_NODE_VSN":    "vsn",
+	"HOST":               "host",
+	"HOSTNAME":           "pod",
+}
+
+// metadataFromPodLabels maps labels that the edge-scheduler puts on plugin pods to metadata
+var metadataFromPodLabels = map[string]string{
+	"app":                               "plugin_name",
+	"sagecontinuum.org/plugin-task":     "plugin_task",
+	"sagecontinuum.org/plugin-job":      "plugin_job",
+	"sagecontinuum.org/plugin-instance": "plugin_instance",
+}
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// measurementMetaKey is the entry of an event with the meta of its measurement as
+// map[string]string, e.g. of a measurement extracted from logs
+const measurementMetaKey = "meta"
+
+// Metadata describes the plugin and where it runs, e.g. plugin_name, plugin_task,
+// plugin_job, node, host, pod and container. Keys follow the plugin meta of edge-scheduler
+type Metadata map[string]string
+
+func (m Metadata) addEnv(env []string) {
+	for _, kv := range env {
+		k, v, found := strings.Cut(kv, "=")
+		if key, known := metadataFromEnv[k]; found && known && v != "" {
+			m[key] = v
+		}
+	}
+}
+
+// addPodInfo reads files of the Kubernetes downward API volume. The labels file
+// has a key="value" line per label
+func (m Metadata) addPodInfo(podInfoDir string) {
+	for file, key := range map[string]string{"name": "pod", "namespace": "namespace"} {
+		if buf, err := os.ReadFile(path.Join(podInfoDir, file)); err == nil && len(bytes.TrimSpace(buf)) > 0 {
+			m[key] = string(bytes.TrimSpace(buf))
+		}
+	}
+	f, err := os.Open(path.Join(podInfoDir, "labels"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, quoted, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		v, err := strconv.Unquote(quoted)
+		if key, known := metadataFromPodLabels[k]; err == nil && known && v != "" {
+			m[key] = v
+		}
+	}
+}
+
+// addContainer takes the ID of the plugin's container from its cgroup path
+func (m Metadata) addContainer(procDir string, pid int32) {
+	buf, err := os.ReadFile(path.Join(procDir, fmt.Sprint(pid), "cgroup"))
+	if err != nil {
+		return
+	}
+	if ids := containerIDPattern.FindAllString(string(buf), -1); len(ids) > 0 {
+		m["container"] = ids[len(ids)-1][:12]
+	}
+}
+
+// GatherPodMetadata gathers metadata of the pod from the controller's environment
+// variables and the downward API volume, which are known before the plugin is found
+func GatherPodMetadata(podInfoDir string, env []string) Metadata {
+	m := Metadata{}
+	m.addEnv(env)
+	if podInfoDir != "" {
+		m.addPodInfo(podInfoDir)
+	}
+	return m
+}
+
+// GatherMetadata gathers metadata from the controller's environment variables, environment
+// variables of the plugin process, the plugin's cgroup and the downward API volume,
+// with the latter taking precedence
+func GatherMetadata(procDir string, podInfoDir string, env []string, pid int32) Metadata {
+	m := Metadata{}
+	m.addEnv(env)
+	if buf, err := os.ReadFile(path.Join(procDir, fmt.Sprint(pid), "environ")); err == nil {
+		m.addEnv(strings.Split(string(buf), "\x00"))
+	}
+	m.addContainer(procDir, pid)
+	if podInfoDir != "" {
+		m.addPodInfo(podInfoDir)
+	}
+	return m
+}
+
+// Enrich adds the metadata to the event. Entries of the event are kept
+func (m Metadata) Enrich(e *datatype.Event) {
+	if e.Meta == nil {
+		e.Meta = map[string]interface{}{}
+	}
+	for k, v := range m {
+		if _, exists := e.Meta[k]; !exists {
+			e.Meta[k] = v
+		}
+	}
+}
+
+// WaggleMessage returns the event as a Waggle message carrying the metadata. Meta of
+// the event's measurement takes precedence over the metadata
+func (m Metadata) WaggleMessage(e datatype.Event) *datatype.WaggleMessage {
+	msg := e.ToWaggleMessage()
+	if msg == nil {
+		return nil
+	}
+	for k, v := range m {
+		msg.Meta[k] = v
+	}
+	if meta, ok := e.Meta[measurementMetaKey].(map[string]string); ok {
+		for k, v := range meta {
+			msg.Meta[k] = v
+		}
+	}
+	return msg
+}
+
+// Labels returns the metadata as Prometheus labels
+func (m Metadata) Labels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	for k, v := range m {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Attrs returns the metadata as sorted key-value pairs for logging
+func (m Metadata) Attrs() []any {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]any, 0, 2*len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, k, m[k])
+	}
+	return attrs
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestGatherMetadata(t *testing.T) {
+	procDir := t.TempDir()
+	podInfoDir := t.TempDir()
+	containerID := strings.Repeat("ab", 32)
+	writeTestSysfsFile(t, path.Join(procDir, "42", "environ"), "WAGGLE_APP_ID=from-plugin\x00HOST=node-1\x00PATH=/usr/bin\x00")
+	writeTestSysfsFile(t, path.Join(procDir, "42", "cgroup"), "0::/kubepods/besteffort/pod1234/"+containerID+"\n")
+	writeTestSysfsFile(t, path.Join(podInfoDir, "name"), "myplugin-abcde\n")
+	writeTestSysfsFile(t, path.Join(podInfoDir, "namespace"), "default\n")
+	writeTestSysfsFile(t, path.Join(podInfoDir, "labels"), "app=\"myplugin\"\nsagecontinuum.org/plugin-job=\"myjob\"\nsagecontinuum.org/plugin-task=\"mytask\"\nrole=\"plugin\"\n")
+
+	m := GatherMetadata(procDir, podInfoDir, []string{"WAGGLE_APP_ID=from-controller", "WAGGLE_NODE_VSN=W001", "HOSTNAME=controller"}, 42)
+	assert.DeepEqual(t, m, Metadata{
+		"app_id":      "from-plugin",
+		"vsn":         "W001",
+		"host":        "node-1",
+		"pod":         "myplugin-abcde",
+		"namespace":   "default",
+		"container":   containerID[:12],
+		"plugin_name": "myplugin",
+		"plugin_task": "mytask",
+		"plugin_job":  "myjob",
+	})
+}
+
+func TestMetadataEnrichment(t *testing.T) {
+	m := Metadata{"plugin_name": "myplugin", "pid": "ignored"}
+	e := datatype.NewEventBuilder(EventPluginBudgetWarn).AddValue("over budget").AddEntry("pid", 42).Build()
+	m.Enrich(&e)
+	assert.Equal(t, e.Meta["plugin_name"], "myplugin")
+	assert.Equal(t, e.Meta["pid"], 42)
+
+	msg := m.WaggleMessage(datatype.NewEventBuilder(datatype.EventPluginPerfCPU).AddValue(1.5).Build())
+	assert.Equal(t, msg.Value, 1.5)
+	assert.Equal(t, msg.Meta["plugin_name"], "myplugin")
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "test"})
+	prometheus.WrapRegistererWith(Metadata{"plugin_name": "myplugin"}.Labels(), reg).MustRegister(counter)
+	assert.NilError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_total test
+# TYPE test_total counter
+test_total{plugin_name="myplugin"} 0
+`), "test_total"))
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// metrics of the plugin controller itself
+var (
+	collectionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "plugin_controller_collection_duration_seconds",
+		Help:    "Duration of collecting measurements in seconds per source",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"source"})
+	collectionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "plugin_controller_collection_errors_total",
+		Help: "Number of failed collections per source",
+	}, []string{"source"})
+	rabbitMQPublished = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plugin_controller_rabbitmq_published_total",
+		Help: "Number of messages published to RabbitMQ",
+	})
+	rabbitMQPublishFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plugin_controller_rabbitmq_publish_failures_total",
+		Help: "Number of messages failed to publish to RabbitMQ, including messages dropped because the queue is full",
+	})
+	publishRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "plugin_controller_publish_rate_limited_total",
+		Help: "Number of messages not published because their route is over its rate limit",
+	}, []string{"route"})
+	proxyMeasurements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "plugin_controller_proxy_measurements_total",
+		Help: "Number of measurements received by the publishing proxy per protocol and result",
+	}, []string{"protocol", "result"})
+	outputUploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "plugin_controller_output_uploads_total",
+		Help: "Number of uploads of output files and their upload messages per result",
+	}, []string{"result"})
+	logLinesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "plugin_controller_log_lines_dropped_total",
+		Help: "Number of lines of the plugin's output dropped by log rules per reason",
+	}, []string{"reason"})
+	storeSamplesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plugin_controller_store_samples_dropped_total",
+		Help: "Number of samples not stored because the queue of the series store is full",
+	})
+	pidSearchAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "plugin_controller_pid_search_attempts_total",
+		Help: "Number of attempts to search for the plugin PID",
+	})
+)
+
+// observeCollection records the duration and the result of a collection from the source
+func observeCollection(source string, start time.Time, err error) {
+	collectionDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	if err != nil {
+		collectionErrors.WithLabelValues(source).Inc()
+	}
+}
+
+// registerControllerMetrics registers metrics of the plugin controller itself, including
+// CPU and memory of the controller process and its build information
+func registerControllerMetrics(reg prometheus.Registerer, version string, queueDepth func() float64) {
+	reg.MustRegister(
+		collectionDuration,
+		collectionErrors,
+		rabbitMQPublished,
+		rabbitMQPublishFailures,
+		publishRateLimited,
+		proxyMeasurements,
+		outputUploads,
+		logLinesDropped,
+		storeSamplesDropped,
+		pidSearchAttempts,
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: "plugin_controller"}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "plugin_controller_rabbitmq_queue_depth",
+			Help: "Number of messages waiting to be published to RabbitMQ",
+		}, queueDepth),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "plugin_controller_build_info",
+			Help:        "Build information of the plugin controller",
+			ConstLabels: prometheus.Labels{"version": version, "goversion": runtime.Version()},
+		}, func() float64 { return 1 }),
+	)
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/v3/assert"
+)
+
+func TestRegisterControllerMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	registerControllerMetrics(prometheus.WrapRegistererWith(prometheus.Labels{"pod": "test-pipeline-0"}, reg), "0.1.0", func() float64 { return 3 })
+	assert.NilError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP plugin_controller_rabbitmq_queue_depth Number of messages waiting to be published to RabbitMQ
+# TYPE plugin_controller_rabbitmq_queue_depth gauge
+plugin_controller_rabbitmq_queue_depth{pod="test-pipeline-0"} 3
+`), "plugin_controller_rabbitmq_queue_depth"))
+	families, err := reg.Gather()
+	assert.NilError(t, err)
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{"plugin_controller_build_info", "plugin_controller_pid_search_attempts_total", "plugin_controller_process_cpu_seconds_total"} {
+		assert.Assert(t, names[name], name)
+	}
+}
+
+func TestObserveCollection(t *testing.T) {
+	errors := testutil.ToFloat64(collectionErrors.WithLabelValues("test"))
+	observeCollection("test", time.Now(), nil)
+	observeCollection("test", time.Now(), fmt.Errorf("failed to read"))
+	assert.Equal(t, testutil.ToFloat64(collectionErrors.WithLabelValues("test"))-errors, 1.)
+	assert.Equal(t, testutil.CollectAndCount(collectionDuration, "plugin_controller_collection_duration_seconds") > 0, true)
+}
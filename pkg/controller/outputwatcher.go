@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// uploadMessageName is the name of the Waggle message that announces an upload
+const uploadMessageName = "upload"
+
+// uploadJob is a file waiting for its retry. Once the file is uploaded, its name and
+// timestamp are kept, so a retry of the upload message does not upload the file again
+type uploadJob struct {
+	path      string
+	attempts  int
+	next      time.Time
+	name      string
+	timestamp int64
+}
+
+// OutputWatcher watches the plugin's output directory and publishes every new file as
+// a Waggle upload. A file is taken as complete once it has not changed for Debounce.
+// Hidden files and files ending with .tmp or .part are ignored, so writers that rename
+// finished files are picked up when they rename. A failed upload or upload message is
+// retried with exponential backoff up to MaxRetries times. Subdirectories are not watched
+type OutputWatcher struct {
+	Dir           string
+	Debounce      time.Duration
+	RetryInterval time.Duration
+	MaxRetries    int
+	Scope         string
+	uploader      Uploader
+	publisher     messagePublisher
+	metadata      Metadata
+	pending       map[string]time.Time
+	retries       []*uploadJob
+	quit          chan struct{}
+	stopped       chan struct{}
+	log           *slog.Logger
+}
+
+func NewOutputWatcher(c ControllerConfig, uploader Uploader, publisher messagePublisher, metadata Metadata) *OutputWatcher {
+	return &OutputWatcher{
+		Dir:           c.OutputDir,
+		Debounce:      time.Duration(c.OutputDebounce) * time.Millisecond,
+		RetryInterval: 5 * time.Second,
+		MaxRetries:    c.OutputUploadRetries,
+		Scope:         c.MetricsPublishingScope,
+		uploader:      uploader,
+		publisher:     publisher,
+		metadata:      metadata,
+		pending:       map[string]time.Time{},
+		quit:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		log:           componentLogger("output").With("dir", c.OutputDir, "uploader", uploader.Name()),
+	}
+}
+
+func isPartialOutput(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part")
+}
+
+// changed marks the file as changed at now
+func (w *OutputWatcher) changed(filePath string, now time.Time) {
+	if isPartialOutput(path.Base(filePath)) {
+		return
+	}
+	w.pending[filePath] = now
+}
+
+// upload uploads the file and keeps its name and timestamp in the job
+func (w *OutputWatcher) upload(job *uploadJob, now time.Time) error {
+	timestamp := now.UnixNano()
+	name, err := w.uploader.Upload(job.path, timestamp, w.uploadMeta(job))
+	if err != nil {
+		return err
+	}
+	job.name, job.timestamp = name, timestamp
+	return nil
+}
+
+func (w *OutputWatcher) uploadMeta(job *uploadJob) map[string]string {
+	meta := map[string]string{}
+	for k, v := range w.metadata {
+		meta[k] = v
+	}
+	meta["filename"] = path.Base(job.path)
+	return meta
+}
+
+// attempt uploads the file unless it is uploaded already and publishes its upload
+// message. On failure of either, the job is queued for a retry of the failed step
+// unless it has run out of retries
+func (w *OutputWatcher) attempt(job *uploadJob, now time.Time) {
+	if job.name == "" {
+		if err := w.upload(job, now); err != nil {
+			if os.IsNotExist(err) {
+				w.log.Warn("file is removed before upload", "file", job.path)
+				return
+			}
+			outputUploads.WithLabelValues("upload_failed").Inc()
+			w.retry(job, now, "failed to upload file", err)
+			return
+		}
+		outputUploads.WithLabelValues("uploaded").Inc()
+	}
+	message := datatype.NewMessage(uploadMessageName, job.name, job.timestamp, w.uploadMeta(job))
+	if err := w.publisher.Publish(message, w.Scope); err != nil {
+		outputUploads.WithLabelValues("publish_failed").Inc()
+		w.retry(job, now, "failed to publish upload", err)
+		return
+	}
+	outputUploads.WithLabelValues("published").Inc()
+	w.log.Info("file uploaded", "file", job.path, "name", job.name, "attempts", job.attempts+1)
+}
+
+// retry queues the failed job with exponential backoff, or gives up on it once it
+// has run out of retries
+func (w *OutputWatcher) retry(job *uploadJob, now time.Time, msg string, err error) {
+	job.attempts++
+	if job.attempts > w.MaxRetries {
+		outputUploads.WithLabelValues("given_up").Inc()
+		w.log.Error(msg+". giving up", "file", job.path, "attempts", job.attempts, "error", err)
+		return
+	}
+	job.next = now.Add(w.RetryInterval * time.Duration(1<<(job.attempts-1)))
+	w.log.Warn(msg+". retrying", "file", job.path, "attempts", job.attempts, "next", job.next, "error", err)
+	w.retries = append(w.retries, job)
+}
+
+// flush uploads files that have settled and files due for a retry
+func (w *OutputWatcher) flush(now time.Time) {
+	var settled []string
+	for filePath, last := range w.pending {
+		if now.Sub(last) < w.Debounce {
+			continue
+		}
+		// a write may have happened without an event, e.g. on the polling watcher
+		if info, err := os.Stat(filePath); err == nil && info.ModTime().After(last) && now.Sub(info.ModTime()) < w.Debounce {
+			w.pending[filePath] = info.ModTime()
+			continue
+		}
+		settled = append(settled, filePath)
+	}
+	sort.Strings(settled)
+	for _, filePath := range settled {
+		delete(w.pending, filePath)
+		w.attempt(&uploadJob{path: filePath}, now)
+	}
+	retries := w.retries
+	w.retries = nil
+	for _, job := range retries {
+		if now.Before(job.next) {
+			w.retries = append(w.retries, job)
+			continue
+		}
+		w.attempt(job, now)
+	}
+}
+
+// Stop stops Run. It returns right away if Run has already returned
+func (w *OutputWatcher) Stop() {
+	select {
+	case w.quit <- struct{}{}:
+	case <-w.stopped:
+	}
+}
+
+// Run watches the directory until stopped. Files already in the directory are not uploaded
+func (w *OutputWatcher) Run() {
+	defer close(w.stopped)
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		w.log.Error("failed to create output directory", "error", err)
+		return
+	}
+	changes := make(chan string)
+	stopWatching := make(chan struct{})
+	go func() {
+		if err := watchDirectory(w.Dir, changes, stopWatching); err != nil {
+			w.log.Error("failed to watch output directory", "error", err)
+		}
+	}()
+	w.log.Info("watching output directory")
+	tick := w.Debounce / 2
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	for {
+		select {
+		case filePath := <-changes:
+			w.changed(filePath, time.Now())
+		case <-ticker.C:
+			w.flush(time.Now())
+		case <-w.quit:
+			ticker.Stop()
+			close(stopWatching)
+			return
+		}
+	}
+}
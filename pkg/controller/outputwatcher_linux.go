@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// watchDirectory sends the path of a file in dir when the file is written or moved
+// into dir. It uses inotify and returns when quit is closed
+func watchDirectory(dir string, changes chan<- string, quit <-chan struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// the non-blocking descriptor goes to the runtime poller, so closing it unblocks Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+	mask := uint32(syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	go func() {
+		<-quit
+		f.Close()
+	}()
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// each event is wd, mask, cookie and len followed by the name padded to len
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			eventMask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+nameLen]), "\x00")
+			offset = start + nameLen
+			if eventMask&syscall.IN_ISDIR != 0 || name == "" {
+				continue
+			}
+			select {
+			case changes <- path.Join(dir, name):
+			case <-quit:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build !linux
+
+package controller
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// watchDirectory sends the path of a file in dir when the file is created or modified.
+// Without inotify, it polls the directory every second and returns when quit is closed
+func watchDirectory(dir string, changes chan<- string, quit <-chan struct{}) error {
+	seen := map[string]time.Time{}
+	scan := func(notify bool) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || entry.IsDir() {
+				continue
+			}
+			if last, found := seen[entry.Name()]; found && !info.ModTime().After(last) {
+				continue
+			}
+			seen[entry.Name()] = info.ModTime()
+			if !notify {
+				continue
+			}
+			select {
+			case changes <- path.Join(dir, entry.Name()):
+			case <-quit:
+				return nil
+			}
+		}
+		return nil
+	}
+	// files already in the directory are not reported
+	if err := scan(false); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := scan(true); err != nil {
+				return err
+			}
+		case <-quit:
+			return nil
+		}
+	}
+}
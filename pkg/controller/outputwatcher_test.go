@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/v3/assert"
+)
+
+// testUploader fails the first failures uploads
+type testUploader struct {
+	failures int
+	uploaded []string
+}
+
+func (u *testUploader) Name() string {
+	return "test"
+}
+
+func (u *testUploader) Upload(filePath string, timestamp int64, meta map[string]string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	if u.failures > 0 {
+		u.failures--
+		return "", fmt.Errorf("upload agent is not available")
+	}
+	u.uploaded = append(u.uploaded, filePath)
+	return fmt.Sprintf("%d-%s", timestamp, path.Base(filePath)), nil
+}
+
+func TestOutputWatcherDebounce(t *testing.T) {
+	uploader := &testUploader{}
+	publisher := &testPublisher{capacity: 10}
+	w := NewOutputWatcher(ControllerConfig{
+		OutputDir:              t.TempDir(),
+		OutputDebounce:         1000,
+		MetricsPublishingScope: "beehive",
+	}, uploader, publisher, Metadata{"plugin_name": "test-pipeline"})
+	now := time.Now()
+	image := path.Join(w.Dir, "image.jpg")
+	writeTestSysfsFile(t, image, "jpeg")
+	os.Chtimes(image, now, now)
+	w.changed(image, now)
+	w.changed(path.Join(w.Dir, ".image.jpg.swp"), now)
+	w.changed(path.Join(w.Dir, "result.part"), now)
+	assert.Equal(t, len(w.pending), 1)
+
+	// the file is still being written
+	w.changed(image, now.Add(500*time.Millisecond))
+	w.flush(now.Add(time.Second))
+	assert.Equal(t, len(uploader.uploaded), 0)
+
+	w.flush(now.Add(1500 * time.Millisecond))
+	assert.DeepEqual(t, uploader.uploaded, []string{image})
+	assert.Equal(t, len(w.pending), 0)
+	assert.Equal(t, publisher.count(), 1)
+	msg := publisher.messages[0]
+	assert.Equal(t, msg.Name, "upload")
+	assert.Equal(t, msg.Value, fmt.Sprintf("%d-image.jpg", msg.Timestamp))
+	assert.Equal(t, msg.Meta["filename"], "image.jpg")
+	assert.Equal(t, msg.Meta["plugin_name"], "test-pipeline")
+	assert.Equal(t, publisher.scopes[0], "beehive")
+}
+
+func TestOutputWatcherRetry(t *testing.T) {
+	uploader := &testUploader{failures: 2}
+	publisher := &testPublisher{capacity: 10}
+	w := NewOutputWatcher(ControllerConfig{
+		OutputDir:           t.TempDir(),
+		OutputDebounce:      1000,
+		OutputUploadRetries: 2,
+	}, uploader, publisher, Metadata{})
+	now := time.Now().Add(-time.Minute)
+	result := path.Join(w.Dir, "result.json")
+	writeTestSysfsFile(t, result, "{}")
+	os.Chtimes(result, now, now)
+	w.changed(result, now)
+
+	now = now.Add(time.Second)
+	w.flush(now)
+	assert.Equal(t, len(w.retries), 1)
+	// the retry is not due yet
+	w.flush(now.Add(w.RetryInterval / 2))
+	assert.Equal(t, len(w.retries), 1)
+	now = now.Add(w.RetryInterval)
+	w.flush(now)
+	assert.Equal(t, w.retries[0].attempts, 2)
+	// the backoff doubles
+	w.flush(now.Add(w.RetryInterval))
+	assert.Equal(t, publisher.count(), 0)
+	w.flush(now.Add(2 * w.RetryInterval))
+	assert.Equal(t, len(w.retries), 0)
+	assert.Equal(t, publisher.count(), 1)
+
+	// a file failing more than the retries is dropped
+	uploader.failures = 10
+	w.changed(result, now)
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Minute)
+		w.flush(now)
+	}
+	assert.Equal(t, len(w.retries), 0)
+	assert.Equal(t, publisher.count(), 1)
+}
+
+func TestOutputWatcherRetryPublish(t *testing.T) {
+	uploader := &testUploader{}
+	publisher := &testPublisher{capacity: 0}
+	w := NewOutputWatcher(ControllerConfig{
+		OutputDir:           t.TempDir(),
+		OutputDebounce:      1000,
+		OutputUploadRetries: 2,
+	}, uploader, publisher, Metadata{})
+	counts := func() []float64 {
+		var c []float64
+		for _, result := range []string{"uploaded", "publish_failed", "published"} {
+			c = append(c, testutil.ToFloat64(outputUploads.WithLabelValues(result)))
+		}
+		return c
+	}
+	before := counts()
+	now := time.Now().Add(-time.Minute)
+	result := path.Join(w.Dir, "result.json")
+	writeTestSysfsFile(t, result, "{}")
+	os.Chtimes(result, now, now)
+	w.changed(result, now)
+	w.flush(now.Add(time.Second))
+	assert.Equal(t, len(w.retries), 1)
+
+	// the file is uploaded once and the retry publishes the same upload
+	publisher.capacity = 1
+	w.flush(now.Add(time.Minute))
+	assert.Equal(t, len(w.retries), 0)
+	assert.DeepEqual(t, uploader.uploaded, []string{result})
+	assert.Equal(t, publisher.count(), 1)
+	msg := publisher.messages[0]
+	assert.Equal(t, msg.Value, fmt.Sprintf("%d-result.json", now.Add(time.Second).UnixNano()))
+	assert.Equal(t, msg.Timestamp, now.Add(time.Second).UnixNano())
+	// the upload and the failed publish are counted apart
+	after := counts()
+	for i, expected := range []float64{1, 1, 1} {
+		assert.Equal(t, after[i]-before[i], expected)
+	}
+}
+
+func TestOutputWatcherStopAfterFailedRun(t *testing.T) {
+	// the directory cannot be created under a file
+	dir := path.Join(t.TempDir(), "file")
+	writeTestSysfsFile(t, dir, "")
+	w := NewOutputWatcher(ControllerConfig{OutputDir: path.Join(dir, "output")}, &testUploader{}, &testPublisher{}, Metadata{})
+	w.Run()
+	w.Stop()
+}
+
+func TestOutputWatcherRun(t *testing.T) {
+	publisher := &testPublisher{capacity: 10}
+	w := NewOutputWatcher(ControllerConfig{OutputDir: t.TempDir(), OutputDebounce: 200}, &testUploader{}, publisher, Metadata{})
+	go w.Run()
+	defer w.Stop()
+	// gives the watcher time to start watching
+	time.Sleep(200 * time.Millisecond)
+	writeTestSysfsFile(t, path.Join(w.Dir, "detection.tmp"), "partial")
+	assert.NilError(t, os.Rename(path.Join(w.Dir, "detection.tmp"), path.Join(w.Dir, "detection.json")))
+	deadline := time.Now().Add(5 * time.Second)
+	for publisher.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, publisher.count(), 1)
+	assert.Equal(t, publisher.messages[0].Meta["filename"], "detection.json")
+}
+
+func TestWaggleUploader(t *testing.T) {
+	src := path.Join(t.TempDir(), "image.jpg")
+	writeTestSysfsFile(t, src, "jpeg")
+	u := &WaggleUploader{Dir: path.Join(t.TempDir(), "uploads")}
+	name, err := u.Upload(src, 1690000000000000000, map[string]string{"camera": "left"})
+	assert.NilError(t, err)
+	assert.Equal(t, name, "1690000000000000000-image.jpg")
+	buf, err := os.ReadFile(path.Join(u.Dir, name, "data"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf), "jpeg")
+	buf, err = os.ReadFile(path.Join(u.Dir, name, "meta"))
+	assert.NilError(t, err)
+	var meta map[string]interface{}
+	assert.NilError(t, json.Unmarshal(buf, &meta))
+	assert.DeepEqual(t, meta, map[string]interface{}{
+		"timestamp":    1.69e18,
+		"shape":        []interface{}{},
+		"checksum-md5": "ab4f3ccba74857c5f2ba0d5b7dbf65e1",
+		"meta":         map[string]interface{}{"camera": "left"},
+	})
+	// no temporary directory is left behind
+	entries, err := os.ReadDir(u.Dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	info, err := os.Stat(path.Join(u.Dir, name))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+}
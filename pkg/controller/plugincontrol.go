@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+var controlSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGSTOP": syscall.SIGSTOP,
+	"SIGTSTP": syscall.SIGTSTP,
+}
+
+// parseSignal returns the signal for names like "SIGUSR1" and "USR1", or for its number
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if n, err := strconv.Atoi(name); err == nil {
+		for _, sig := range controlSignals {
+			if int(sig) == n {
+				return sig, nil
+			}
+		}
+		return 0, fmt.Errorf("signal %d is not allowed", n)
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, found := controlSignals[name]; found {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("signal %q is not allowed", s)
+}
+
+// PluginControl sends signals to the plugin process. When processTree is set,
+// signals are also delivered to all descendants of the plugin process
+type PluginControl struct {
+	proc        *process.Process
+	processTree bool
+	procDir     string
+}
+
+func NewPluginControl(c ControllerConfig, proc *process.Process) *PluginControl {
+	procDir := c.ProcfsRoot
+	if procDir == "" {
+		procDir = "/proc"
+	}
+	return &PluginControl{
+		proc:        proc,
+		processTree: c.PluginControlProcessTree,
+		procDir:     procDir,
+	}
+}
+
+// targets returns the plugin PID followed by PIDs of its descendants if the process tree is covered
+func (pc *PluginControl) targets() []int32 {
+	if !pc.processTree {
+		return []int32{pc.proc.Pid}
+	}
+	pids, err := listProcessTree(pc.procDir, pc.proc.Pid)
+	if err != nil {
+		return []int32{pc.proc.Pid}
+	}
+	return pids
+}
+
+// Signal sends the signal to the plugin and returns PIDs that received the signal
+func (pc *PluginControl) Signal(sig syscall.Signal) ([]int32, error) {
+	var pids []int32
+	var errs []error
+	for _, pid := range pc.targets() {
+		if err := syscall.Kill(int(pid), sig); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send %s to %d: %s", sig, pid, err.Error()))
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, errors.Join(errs...)
+}
+
+// Pause stops the plugin with SIGSTOP
+func (pc *PluginControl) Pause() ([]int32, error) {
+	return pc.Signal(syscall.SIGSTOP)
+}
+
+// Resume continues the plugin with SIGCONT
+func (pc *PluginControl) Resume() ([]int32, error) {
+	return pc.Signal(syscall.SIGCONT)
+}
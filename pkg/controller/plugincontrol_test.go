@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"SIGUSR1": syscall.SIGUSR1,
+		"usr1":    syscall.SIGUSR1,
+		" TERM ":  syscall.SIGTERM,
+		"9":       syscall.SIGKILL,
+	} {
+		sig, err := parseSignal(s)
+		assert.NilError(t, err, s)
+		assert.Equal(t, sig, expected, s)
+	}
+	for _, s := range []string{"", "SIGSEGV", "11", "USR3"} {
+		_, err := parseSignal(s)
+		assert.ErrorContains(t, err, "is not allowed", s)
+	}
+}
+
+// startTestProcessTree starts a shell with a child and returns the command with PIDs
+// of the shell and the child
+func startTestProcessTree(t *testing.T) (*exec.Cmd, []int32) {
+	cmd := exec.Command("sh", "-c", "sleep 100 & wait")
+	assert.NilError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pids, err := listProcessTree("/proc", int32(cmd.Process.Pid))
+		assert.NilError(t, err)
+		if len(pids) == 2 {
+			t.Cleanup(func() { syscall.Kill(int(pids[1]), syscall.SIGKILL) })
+			return cmd, pids
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("child of the shell is not started")
+	return nil, nil
+}
+
+// waitForProcessState waits until the process is in the state
+func waitForProcessState(t *testing.T, pid int32, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stat, err := readProcStat(path.Join("/proc", fmt.Sprint(pid), "stat"))
+		assert.NilError(t, err)
+		if stat.State == state {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("process %d is not in state %s", pid, state)
+}
+
+func TestPluginControl(t *testing.T) {
+	cmd, pids := startTestProcessTree(t)
+	pc := NewPluginControl(ControllerConfig{PluginControlProcessTree: true}, &process.Process{Pid: pids[0]})
+
+	paused, err := pc.Pause()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, paused, pids)
+	waitForProcessState(t, pids[0], "T")
+	waitForProcessState(t, pids[1], "T")
+
+	resumed, err := pc.Resume()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, resumed, pids)
+	waitForProcessState(t, pids[0], "S")
+	waitForProcessState(t, pids[1], "S")
+
+	signaled, err := pc.Signal(syscall.SIGTERM)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, signaled, pids)
+	assert.ErrorContains(t, cmd.Wait(), "signal: terminated")
+}
+
+func TestPluginControlProcess(t *testing.T) {
+	_, pids := startTestProcessTree(t)
+	// without the process tree, only the plugin process receives signals
+	pc := NewPluginControl(ControllerConfig{}, &process.Process{Pid: pids[0]})
+	paused, err := pc.Pause()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, paused, pids[:1])
+	waitForProcessState(t, pids[0], "T")
+	waitForProcessState(t, pids[1], "S")
+	_, err = pc.Resume()
+	assert.NilError(t, err)
+}
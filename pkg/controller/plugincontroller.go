@@ -3,13 +3,13 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
 	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
-	"github.com/waggle-sensor/edge-scheduler/pkg/logger"
 	"gopkg.in/cenkalti/backoff.v1"
 
 	"github.com/shirou/gopsutil/v3/process"
@@ -25,39 +25,127 @@ const (
 )
 
 type ControllerConfig struct {
-	EnableCPUPerformanceLogging   bool
-	EnableGPUPerformanceLogging   bool
-	PerformanceCollectionInterval int
-	PluginProcessName             string
-	AppCgroupDir                  string
-	GPUMetricHost                 string
-	EnableMetricsPublishing       bool
-	MetricsPublishingScope        string
-	RabbitMQHost                  string
-	RabbitMQPort                  int
-	RabbitMQUsername              string
-	RabbitMQPassword              string
-	RabbitMQAppID                 string
+	EnableCPUPerformanceLogging    bool
+	EnableGPUPerformanceLogging    bool
+	PerformanceCollectionInterval  int
+	PluginProcessName              string
+	AppCgroupDir                   string
+	GPUMetricHost                  string
+	GPUMetricPort                  int
+	GPUMetricTimeout               int
+	GPUMetricTLS                   bool
+	GPUMetricCACert                string
+	GPUMetricInsecureSkipVerify    bool
+	GPUMetricBearerToken           string
+	GPUBackend                     string
+	NvidiaSMIPath                  string
+	EnableMetricsPublishing        bool
+	MetricsPublishingScope         string
+	RabbitMQHost                   string
+	RabbitMQPort                   int
+	RabbitMQUsername               string
+	RabbitMQPassword               string
+	RabbitMQAppID                  string
+	Version                        string
+	EnableResourceBudget           bool
+	BudgetCPUSeconds               float64
+	BudgetMemoryBytes              float64
+	BudgetWallClockSeconds         int
+	BudgetGracePeriod              int
+	BudgetPauseDuration            int
+	APIAuthToken                   string
+	PluginControlProcessTree       bool
+	EnableThreadPerformanceLogging bool
+	ThreadMetricsMaxNames          int
+	EnableRunSummary               bool
+	RunSummaryPath                 string
+	EnablePerformanceWindows       bool
+	PublishWindowedStats           bool
+	EnableEnergyEstimation         bool
+	SysfsRoot                      string
+	LogLevel                       string
+	LogFormat                      string
+	EventHistorySize               int
+	EventHistoryMaxAge             int
+	StoreDir                       string
+	StoreRetentionDays             int
+	StoreDownsampleAfterDays       int
+	StoreDownsampleStep            int
+	EnableAggregatedPublishing     bool
+	AggregationWindow              int
+	EnableRemoteControl            bool
+	ControlExchange                string
+	ControlQueue                   string
+	ControlTopic                   string
+	EnableResourceProfile          bool
+	ProfileWarmup                  int
+	ProfileInterval                int
+	PublishingRoutes               PublishingRoutes
+	PodInfoDir                     string
+	DaemonMode                     bool
+	HostCgroupRoot                 string
+	ProcfsRoot                     string
+	HostProcfsRoot                 string
+	EnablePublishingProxy          bool
+	PublishingProxyAddress         string
+	PublishingProxyUDPAddress      string
+	OutputDir                      string
+	OutputDebounce                 int
+	OutputUploadRetries            int
+	UploadBackend                  string
+	UploadDir                      string
+	LogRules                       []LogRule
+	PluginStdoutPath               string
+	PluginStderrPath               string
+	PluginCommand                  []string
 }
 
 type Controller struct {
 	config     ControllerConfig
 	pluginProc *process.Process
 	rmq        *interfacing.RabbitMQHandler
+	publisher  *Publisher
 	apiServer  *APIServer
+	summary    *RunSummary
+	windows    *SampleWindows
+	energy     *EnergyEstimation
+	history    *EventHistory
+	store      *SeriesStore
+	aggregator *PublishAggregator
+	profiler   *ResourceProfiler
+	router     *PublishingRouter
+	supervisor *Supervisor
+	metadata   Metadata
+	exitCode   int
+	log        *slog.Logger
 }
 
 func NewController(c ControllerConfig) *Controller {
 	return &Controller{
 		config:    c,
-		apiServer: NewAPIServer(),
+		apiServer: NewAPIServer(c),
+		router:    NewPublishingRouter(c),
+		log:       componentLogger("controller"),
 	}
 }
 
+// needsCPUSamples tells if a feature consumes CPU and memory samples of the event loop.
+// Otherwise CPU performance is only exported to Prometheus
+func needsCPUSamples(c ControllerConfig) bool {
+	return c.EnableRunSummary || c.EnablePerformanceWindows || c.PublishWindowedStats || c.EnableResourceProfile || c.EnableEnergyEstimation
+}
+
+// needsCPUCollector tells if the CPU collector runs. Features consuming CPU and memory
+// samples need the collector without CPU performance logging
+func needsCPUCollector(c ControllerConfig) bool {
+	return c.EnableCPUPerformanceLogging || needsCPUSamples(c)
+}
+
 // searchForPluginPID finds the plugin process ID from the process namespace and
 // sets the PID in the struct. in case plugin process name is not given, it will
 // search for any user process other than "pause" and "plugin-controller"
 func (c *Controller) searchForPluginPID() error {
+	pidSearchAttempts.Inc()
 	blacklist := map[string]bool{
 		"pause":             true,
 		"plugin-controller": true,
@@ -68,16 +156,16 @@ func (c *Controller) searchForPluginPID() error {
 			return fmt.Errorf("failed to get process %d: %s", pid, err.Error())
 		} else {
 			if pName, err := p.Name(); err == nil {
-				logger.Info.Printf("pid %d (%s) found", pid, pName)
+				c.log.Debug("process found", "process_pid", pid, "process_name", pName)
 				if c.config.PluginProcessName != "" {
 					if c.config.PluginProcessName == pName {
-						logger.Info.Printf("set %d as plugin PID", p.Pid)
+						c.log.Info("plugin process found", "process_pid", p.Pid)
 						c.pluginProc = p
 						return nil
 					}
 				} else {
 					if _, blacklisted := blacklist[pName]; !blacklisted {
-						logger.Info.Printf("%d might be the plugin PID. setting it as plugin PID", p.Pid)
+						c.log.Info("process might be the plugin. setting it as the plugin process", "process_pid", p.Pid, "process_name", pName)
 						c.pluginProc = p
 						return nil
 					}
@@ -95,76 +183,422 @@ func (c *Controller) searchForPluginPID() error {
 }
 
 func (c *Controller) Run() {
-	logger.Info.Println("plugin controller started.")
+	c.log.Info("plugin controller started", "version", c.config.Version)
 	ch := make(chan datatype.Event)
 
 	// Setting up Prometheus metrics
 	reg := prometheus.NewRegistry()
 
-	if c.config.EnableMetricsPublishing {
+	if c.config.EnableMetricsPublishing || c.config.EnableRemoteControl || c.config.EnablePublishingProxy || c.config.OutputDir != "" {
 		rabbitMQURL := fmt.Sprintf("%s:%d", c.config.RabbitMQHost, c.config.RabbitMQPort)
-		logger.Info.Printf("publishing metrics to %s", rabbitMQURL)
+		c.log.Info("publishing metrics", "rabbitmq", rabbitMQURL)
 		c.rmq = interfacing.NewRabbitMQHandler(rabbitMQURL, c.config.RabbitMQUsername, c.config.RabbitMQPassword, "", c.config.RabbitMQAppID)
-		c.rmq.StartLoop()
+		c.publisher = NewPublisher(c.rmq, 100)
+		go c.publisher.Run()
 	}
 
-	if c.config.PluginProcessName != "" {
-		logger.Info.Printf("looking for the plugin process (%s) ...", c.config.PluginProcessName)
-	} else {
-		logger.Info.Printf("no plugin process name is given. looking for any user process in the process namespace")
+	// the log extractor is set up before the plugin starts, so that no line of a
+	// supervised plugin is missed. Lines of the plugin are queued, so the plugin is not
+	// held up by the event loop, which starts after the setup
+	var extractor *LogExtractor
+	if len(c.config.LogRules) > 0 {
+		if e, err := NewLogExtractor(c.config); err != nil {
+			c.log.Error("failed to set up log rules", "error", err)
+		} else {
+			c.log.Info("log rules enabled", "rules", len(c.config.LogRules), "stdout", c.config.PluginStdoutPath, "stderr", c.config.PluginStderrPath)
+			extractor = e
+			extractor.Notifier.Subscribe(ch)
+			extractor.Run()
+		}
 	}
 
-	backOffConfiguration := backoff.NewExponentialBackOff()
-	// it should not stop searching for plugin PID
-	backOffConfiguration.MaxElapsedTime = 0
-	if err := backoff.Retry(c.searchForPluginPID, backOffConfiguration); err != nil {
-		logger.Info.Println(err.Error())
-		return
+	// metrics of the controller and the API server are available while looking for the
+	// plugin. They carry metadata of the pod as the plugin's metadata is not known yet
+	registerControllerMetrics(prometheus.WrapRegistererWith(GatherPodMetadata(c.config.PodInfoDir, os.Environ()).Labels(), reg), c.config.Version, c.publisher.QueueDepth)
+	c.apiServer.Notifier.Subscribe(ch)
+	go c.apiServer.Run(reg)
+
+	if len(c.config.PluginCommand) > 0 {
+		c.supervisor = NewSupervisor(c.config)
+		if extractor != nil {
+			c.supervisor.Lines = extractor.Queue
+		}
+		if err := c.supervisor.Start(); err != nil {
+			c.log.Error("failed to start the plugin", "command", c.config.PluginCommand, "error", err)
+			// shells exit with 127 when a command cannot be run
+			c.exitCode = 127
+			return
+		}
+		// the plugin is our child, so its PID cannot be taken by another process until it is waited
+		c.pluginProc = &process.Process{Pid: int32(c.supervisor.Pid())}
+	} else {
+		if c.config.PluginProcessName != "" {
+			c.log.Info("looking for the plugin process", "plugin", c.config.PluginProcessName)
+		} else {
+			c.log.Info("no plugin process name is given. looking for any user process in the process namespace")
+		}
+
+		backOffConfiguration := backoff.NewExponentialBackOff()
+		// it should not stop searching for plugin PID
+		backOffConfiguration.MaxElapsedTime = 0
+		if err := backoff.Retry(c.searchForPluginPID, backOffConfiguration); err != nil {
+			c.log.Info(err.Error())
+			return
+		}
+	}
+	// every log from here on carries the plugin and its PID
+	pluginName := c.config.PluginProcessName
+	if pluginName == "" {
+		pluginName, _ = c.pluginProc.Name()
+	}
+	slog.SetDefault(slog.Default().With("plugin", pluginName, "pid", c.pluginProc.Pid))
+	c.log = componentLogger("controller")
+	c.metadata = GatherMetadata(c.config.ProcfsRoot, c.config.PodInfoDir, os.Environ(), c.pluginProc.Pid)
+	c.log.Info("plugin metadata gathered", c.metadata.Attrs()...)
+	// every series carries the plugin's metadata
+	registerer := prometheus.WrapRegistererWith(c.metadata.Labels(), reg)
+	startedAt := time.Now()
+	if createTime, err := c.pluginProc.CreateTime(); err == nil {
+		startedAt = time.UnixMilli(createTime)
+	}
+	if c.config.EnableRunSummary {
+		c.summary = NewRunSummary(startedAt)
+	}
+	if c.config.EnableResourceProfile {
+		c.log.Info("resource profiling enabled")
+		c.profiler = NewResourceProfiler(c.config, pluginName, startedAt)
+		c.profiler.Notifier.Subscribe(ch)
+		go c.profiler.Run()
+		c.apiServer.profiler = c.profiler
 	}
-	if c.config.EnableCPUPerformanceLogging {
-		logger.Info.Println("CPU performance measurement enabled")
-		if c.config.AppCgroupDir == "" {
-			logger.Info.Println("plugin cgroup directory is not given.")
-			c.config.AppCgroupDir = fmt.Sprintf("/proc/%d/root/sys/fs/cgroup", c.pluginProc.Pid)
-			logger.Info.Printf("plugin cgroup path found: %s", c.config.AppCgroupDir)
+	pluginCgroup := CgroupFromRoot(c.config.AppCgroupDir)
+	if c.config.AppCgroupDir == "" && (needsCPUCollector(c.config) || c.config.EnableResourceBudget) {
+		if g, err := ResolveCgroup(c.config.ProcfsRoot, c.pluginProc.Pid); err != nil {
+			c.config.AppCgroupDir = fmt.Sprintf("%s/%d/root/sys/fs/cgroup", c.config.ProcfsRoot, c.pluginProc.Pid)
+			pluginCgroup = CgroupFromRoot(c.config.AppCgroupDir)
+			c.log.Warn("failed to resolve the plugin's cgroup. using the cgroup root of the plugin", "cgroup_dir", c.config.AppCgroupDir, "error", err)
+		} else {
+			pluginCgroup = g
+			c.log.Info("plugin cgroup resolved", "version", g.Version, "cpu_dir", g.CPUDir, "memory_dir", g.MemoryDir, "io_dir", g.IODir)
 		}
+	}
+	samplers := map[string]sampler{}
+	if needsCPUCollector(c.config) {
 		p := NewCPUPerformanceLogging(c.config)
-		reg.MustRegister(p)
-		// p.Notifier.Subscribe(ch)
-		// go p.Run()
+		p.Cgroup = pluginCgroup
+		if c.config.EnableCPUPerformanceLogging {
+			c.log.Info("CPU performance measurement enabled")
+			registerer.MustRegister(p)
+		}
+		// the collector samples into the event loop only for features consuming the samples
+		if needsCPUSamples(c.config) {
+			c.log.Info("CPU and memory sampling enabled", "interval_seconds", c.config.PerformanceCollectionInterval)
+			p.Notifier.Subscribe(ch)
+			go p.Run()
+			samplers["cpu"] = p
+		}
+	}
+	if c.config.EnableThreadPerformanceLogging {
+		c.log.Info("per-thread CPU measurement enabled")
+		t := NewThreadPerformanceLogging(c.config, c.pluginProc.Pid)
+		registerer.MustRegister(t)
 	}
 	if c.config.EnableGPUPerformanceLogging {
-		logger.Info.Println("GPU performance measurement enabled")
-		g := NewGPUPerformanceLogging(c.config)
-		g.Notifier.Subscribe(ch)
-		go g.Run()
+		c.log.Info("GPU performance measurement enabled")
+		if g, err := NewGPUPerformanceLogging(c.config, c.pluginProc.Pid); err != nil {
+			c.log.Error("failed to set up GPU performance measurement", "error", err)
+		} else {
+			c.log.Info("reading GPU metrics", "source", g.Source.Name())
+			if collector, ok := g.Source.(prometheus.Collector); ok {
+				registerer.MustRegister(collector)
+			}
+			g.Notifier.Subscribe(ch)
+			go g.Run()
+			samplers["gpu"] = g
+		}
 	}
 
-	go c.apiServer.Run(reg)
+	if c.config.EnableEnergyEstimation {
+		c.log.Info("energy estimation enabled")
+		c.energy = NewEnergyEstimation(c.config)
+		registerer.MustRegister(c.energy)
+		c.energy.Notifier.Subscribe(ch)
+		go c.energy.Run()
+		samplers["energy"] = c.energy
+	}
+	if c.config.EnablePerformanceWindows || c.config.PublishWindowedStats {
+		c.log.Info("windowed performance statistics enabled")
+		c.windows = NewSampleWindows(c.config)
+		registerer.MustRegister(c.windows)
+		if c.config.PublishWindowedStats {
+			c.windows.Notifier.Subscribe(ch)
+			go c.windows.Run()
+		}
+		c.apiServer.windows = c.windows
+	}
+
+	pluginControl := NewPluginControl(c.config, c.pluginProc)
+	if c.config.EnableResourceBudget {
+		c.log.Info("resource budget enforcement enabled")
+		b := NewResourceBudget(c.config, c.pluginProc, pluginControl)
+		b.cpu.Cgroup = pluginCgroup
+		b.Notifier.Subscribe(ch)
+		go b.Run()
+	}
+
+	if c.config.EnableRemoteControl {
+		c.log.Info("remote control enabled", "exchange", c.config.ControlExchange)
+		rc := NewRemoteControl(c.config, c.rmq, c.publisher, pluginControl)
+		for name, s := range samplers {
+			rc.AddSampler(name, s)
+		}
+		go rc.Run()
+	}
+
+	if c.config.EventHistorySize > 0 {
+		c.history = NewEventHistory(c.config)
+		c.apiServer.history = c.history
+	}
+
+	if c.config.EnableMetricsPublishing && c.config.EnableAggregatedPublishing {
+		c.log.Info("aggregated publishing enabled", "window_seconds", c.config.AggregationWindow)
+		c.aggregator = NewPublishAggregator(c.config)
+		c.aggregator.Notifier.Subscribe(ch)
+		go c.aggregator.Run()
+	}
+
+	if c.config.StoreDir != "" {
+		if store, err := NewSeriesStore(c.config); err != nil {
+			c.log.Error("failed to set up series store", "dir", c.config.StoreDir, "error", err)
+		} else {
+			c.log.Info("series store enabled", "dir", c.config.StoreDir)
+			c.store = store
+			c.apiServer.store = store
+			go c.store.Run()
+		}
+	}
+
+	if c.config.OutputDir != "" {
+		if uploader, err := NewUploader(c.config); err != nil {
+			c.log.Error("failed to set up output directory watcher", "dir", c.config.OutputDir, "error", err)
+		} else {
+			c.log.Info("output directory watcher enabled", "dir", c.config.OutputDir, "uploader", uploader.Name())
+			go NewOutputWatcher(c.config, uploader, c.publisher, c.metadata).Run()
+		}
+	}
+	if c.config.EnablePublishingProxy {
+		c.log.Info("publishing proxy enabled", "address", c.config.PublishingProxyAddress, "udp_address", c.config.PublishingProxyUDPAddress)
+		go NewPublishingProxy(c.config, c.publisher, c.metadata).Run()
+	}
+
+	c.apiServer.pluginControl = pluginControl
+	c.apiServer.Ready()
 
 	ticker := time.NewTicker(time.Second)
+	var pluginExited <-chan struct{}
+	if c.supervisor != nil {
+		pluginExited = c.supervisor.Done()
+	}
 	for {
 		select {
+		case <-pluginExited:
+			c.exitCode = c.supervisor.ExitCode()
+			c.log.Info("the plugin exited. plugin-controller terminates with the plugin's exit code", "exit_code", c.exitCode)
+			c.reportExit()
+			c.finish()
+			return
 		case <-ticker.C:
+			// the exit of a supervised plugin is known without probing its PID
+			if c.supervisor != nil {
+				continue
+			}
 			if pluginPidExists, err := process.PidExists(c.pluginProc.Pid); err == nil {
 				if !pluginPidExists {
-					logger.Info.Printf("plugin's PID (%d) does not exist", c.pluginProc.Pid)
+					c.log.Info("plugin PID does not exist")
 					if _, err := os.Stat(PluginProcessStartedPath); errors.Is(err, os.ErrNotExist) {
-						logger.Info.Printf("%s does not exist. the plugin has not yet started.", PluginProcessStartedPath)
+						c.log.Info("the plugin has not yet started", "started_path", PluginProcessStartedPath)
 					} else {
-						logger.Info.Println("the plugin is terminated. plugin-controller terminates successfully.")
+						c.log.Info("the plugin is terminated. plugin-controller terminates successfully")
+						c.finish()
 						return
 					}
 				}
 			} else {
-				logger.Error.Printf("failed to probe plugin PID (%d): %s", c.pluginProc.Pid, err.Error())
+				collectionErrors.WithLabelValues("pid").Inc()
+				c.log.Error("failed to probe plugin PID", "error", err)
 			}
 		case e := <-ch:
-			data, _ := e.EncodeMetaToJson()
-			logger.Info.Printf("%s: %s", e.ToString(), data)
+			c.metadata.Enrich(&e)
+			c.logEvent(e)
+			if c.history != nil {
+				c.history.Add(e)
+			}
+			if c.store != nil {
+				c.store.Observe(e)
+			}
+			if c.summary != nil {
+				c.summary.Observe(e)
+			}
+			if c.profiler != nil {
+				c.profiler.Observe(e)
+			}
+			if c.windows != nil {
+				c.windows.Observe(e)
+			}
+			if c.energy != nil {
+				c.energy.Observe(e)
+			}
 			if c.config.EnableMetricsPublishing {
-				c.rmq.SendWaggleMessageOnNodeAsync(e.ToWaggleMessage(), c.config.MetricsPublishingScope)
+				c.publish(e, c.metadata)
 			}
 		}
 	}
 }
+
+// RunDaemon monitors every plugin container on the node instead of a single plugin.
+// Events of a container carry the container's metadata
+func (c *Controller) RunDaemon() {
+	c.log.Info("plugin controller started in daemon mode", "version", c.config.Version, "cgroup_root", c.config.HostCgroupRoot)
+	ch := make(chan datatype.Event)
+	reg := prometheus.NewRegistry()
+	if c.config.EnableMetricsPublishing {
+		rabbitMQURL := fmt.Sprintf("%s:%d", c.config.RabbitMQHost, c.config.RabbitMQPort)
+		c.log.Info("publishing metrics", "rabbitmq", rabbitMQURL)
+		c.rmq = interfacing.NewRabbitMQHandler(rabbitMQURL, c.config.RabbitMQUsername, c.config.RabbitMQPassword, "", c.config.RabbitMQAppID)
+		c.publisher = NewPublisher(c.rmq, 100)
+		go c.publisher.Run()
+	}
+	daemon := NewNodeDaemon(c.config, os.Environ())
+	c.metadata = daemon.node
+	registerer := prometheus.WrapRegistererWith(c.metadata.Labels(), reg)
+	registerControllerMetrics(registerer, c.config.Version, c.publisher.QueueDepth)
+	registerer.MustRegister(daemon)
+	if err := daemon.Scan(); err != nil {
+		c.log.Error("failed to scan plugin containers", "error", err)
+	}
+	daemon.Notifier.Subscribe(ch)
+	go daemon.Run()
+	if c.config.EventHistorySize > 0 {
+		c.history = NewEventHistory(c.config)
+		c.apiServer.history = c.history
+	}
+	c.apiServer.Ready()
+	go c.apiServer.Run(reg)
+
+	for e := range ch {
+		m := daemon.Metadata(fmt.Sprint(e.Meta["container"]))
+		m.Enrich(&e)
+		c.logEvent(e)
+		if c.history != nil {
+			c.history.Add(e)
+		}
+		if c.config.EnableMetricsPublishing {
+			c.publish(e, m)
+		}
+	}
+}
+
+// finish reports the run of the plugin as the controller is about to exit
+func (c *Controller) finish() {
+	if c.summary != nil {
+		c.reportRunSummary()
+	}
+	if c.profiler != nil {
+		c.reportResourceProfile()
+	}
+	if c.store != nil {
+		c.store.Stop()
+	}
+}
+
+// ExitCode returns the exit status that the controller exits with. It is the exit
+// status of a supervised plugin, and 0 otherwise
+func (c *Controller) ExitCode() int {
+	return c.exitCode
+}
+
+// logEvent logs the event with its meta. The meta is kept in a group so that
+// its keys do not collide with the attributes of the logger
+func (c *Controller) logEvent(e datatype.Event) {
+	c.log.Info("event", "event_type", e.Type, "timestamp", e.Timestamp, "meta", e.Meta)
+}
+
+// publish sends the event to RabbitMQ with the metadata and the scope routed for its type.
+// Raw performance samples are not sent when the windowed statistics are published instead.
+// When aggregated publishing is enabled, numeric samples are published only on the node
+// and their aggregates are routed by the event type of the samples, counting against its
+// rate limit. Samples that are not published by their route are not aggregated either
+func (c *Controller) publish(e datatype.Event, m Metadata) {
+	if c.config.PublishWindowedStats && windowedEventTypes[e.Type] {
+		return
+	}
+	now := time.Now()
+	var scope string
+	if source, aggregated := e.Meta[aggregateSourceEntry].(string); aggregated {
+		var ok bool
+		if scope, ok = c.router.Route(datatype.EventType(source), now); !ok {
+			return
+		}
+	} else if _, ok := c.router.Lookup(e.Type); ok && c.aggregator != nil && c.aggregator.Observe(e) {
+		scope = localPublishingScope
+	} else if scope, ok = c.router.Route(e.Type, now); !ok {
+		return
+	}
+	if err := c.publisher.Publish(m.WaggleMessage(e), scope); err != nil {
+		c.log.Error("failed to publish", "event_type", e.Type, "error", err)
+	}
+}
+
+// reportRunSummary writes the run summary to the configured path and publishes it.
+// The summary is published synchronously as the controller is about to exit
+func (c *Controller) reportRunSummary() {
+	report := c.summary.Report(time.Now())
+	if c.config.RunSummaryPath != "" {
+		if err := report.WriteFile(c.config.RunSummaryPath); err != nil {
+			c.log.Error("failed to write run summary", "path", c.config.RunSummaryPath, "error", err)
+		} else {
+			c.log.Info("run summary written", "path", c.config.RunSummaryPath)
+		}
+	}
+	e, err := report.ToEvent()
+	if err != nil {
+		c.log.Error("failed to encode run summary", "error", err)
+		return
+	}
+	c.logEvent(e)
+	if scope, ok := c.router.Route(e.Type, time.Now()); ok && c.config.EnableMetricsPublishing {
+		if err := c.publisher.PublishSync(c.metadata.WaggleMessage(e), scope); err != nil {
+			c.log.Error("failed to publish run summary", "error", err)
+		}
+	}
+}
+
+// reportExit publishes the exit status of the supervised plugin as the controller is about to exit
+func (c *Controller) reportExit() {
+	e := c.supervisor.ExitEvent()
+	c.metadata.Enrich(&e)
+	c.logEvent(e)
+	if c.history != nil {
+		c.history.Add(e)
+	}
+	if scope, ok := c.router.Route(e.Type, time.Now()); ok && c.config.EnableMetricsPublishing {
+		if err := c.publisher.PublishSync(c.metadata.WaggleMessage(e), scope); err != nil {
+			c.log.Error("failed to publish exit status", "error", err)
+		}
+	}
+}
+
+// reportResourceProfile publishes the final resource profile as the controller is about to exit
+func (c *Controller) reportResourceProfile() {
+	e, err := c.profiler.Profile().ToEvent()
+	if err != nil {
+		c.log.Error("failed to encode resource profile", "error", err)
+		return
+	}
+	c.logEvent(e)
+	if scope, ok := c.router.Route(e.Type, time.Now()); ok && c.config.EnableMetricsPublishing {
+		if err := c.publisher.PublishSync(c.metadata.WaggleMessage(e), scope); err != nil {
+			c.log.Error("failed to publish resource profile", "error", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the USER_HZ that the kernel uses to report process times in /proc.
+// It is 100 on all architectures that Waggle nodes run
+const clockTicks = 100.
+
+type procStat struct {
+	Name  string
+	State string
+	PPID  int32
+	UTime float64
+	STime float64
+	// StartTime is in clock ticks since boot. It is the same in every PID namespace
+	StartTime uint64
+}
+
+// parseProcStat parses a stat file of a process or a thread. The name of the process
+// is enclosed by parentheses and may contain spaces, so fields are counted from the
+// last closing parenthesis
+func parseProcStat(buf []byte) (procStat, error) {
+	s := string(buf)
+	start := strings.IndexByte(s, '(')
+	end := strings.LastIndexByte(s, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("failed to find process name from %q", s)
+	}
+	fields := strings.Fields(s[end+1:])
+	// fields start from the state, which is the 3rd field in proc(5)
+	if len(fields) < 13 {
+		return procStat{}, fmt.Errorf("not enough fields in %q", s)
+	}
+	ppid, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return procStat{}, err
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	var startTime uint64
+	if len(fields) > 19 {
+		startTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	}
+	return procStat{
+		Name:      s[start+1 : end],
+		State:     fields[0],
+		PPID:      int32(ppid),
+		UTime:     float64(utime) / clockTicks,
+		STime:     float64(stime) / clockTicks,
+		StartTime: startTime,
+	}, nil
+}
+
+func readProcStat(statPath string) (procStat, error) {
+	buf, err := os.ReadFile(statPath)
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseProcStat(buf)
+}
+
+// readNSpid returns PIDs of the process in each PID namespace that it is a member of,
+// from the namespace of the procfs to the namespace of the process, from the NSpid line
+// of its status file
+func readNSpid(statusPath string) ([]int32, error) {
+	buf, err := os.ReadFile(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		value, found := strings.CutPrefix(line, "NSpid:")
+		if !found {
+			continue
+		}
+		var pids []int32
+		for _, field := range strings.Fields(value) {
+			pid, err := strconv.ParseInt(field, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			pids = append(pids, int32(pid))
+		}
+		if len(pids) == 0 {
+			break
+		}
+		return pids, nil
+	}
+	return nil, fmt.Errorf("failed to find NSpid from %s", statusPath)
+}
+
+// hostPIDs maps PIDs of processes in procDir to their PIDs in hostProcDir, a procfs of
+// an outer PID namespace, e.g. of the host. A process in hostProcDir is one of the
+// processes if the last PID of its NSpid matches and it started at the same time, as
+// PIDs of other namespaces may be the same
+func hostPIDs(procDir string, hostProcDir string, pids []int32) ([]int32, error) {
+	if hostProcDir == "" || path.Clean(hostProcDir) == path.Clean(procDir) {
+		return pids, nil
+	}
+	type process struct {
+		pid       int32
+		startTime uint64
+	}
+	wanted := map[process]bool{}
+	for _, pid := range pids {
+		// the process may be gone
+		if stat, err := readProcStat(path.Join(procDir, fmt.Sprint(pid), "stat")); err == nil {
+			wanted[process{pid: pid, startTime: stat.StartTime}] = true
+		}
+	}
+	entries, err := os.ReadDir(hostProcDir)
+	if err != nil {
+		return nil, err
+	}
+	var mapped []int32
+	for _, entry := range entries {
+		hostPID, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		// processes of the outer namespace itself have a single PID
+		nspid, err := readNSpid(path.Join(hostProcDir, entry.Name(), "status"))
+		if err != nil || len(nspid) < 2 {
+			continue
+		}
+		stat, err := readProcStat(path.Join(hostProcDir, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		if wanted[process{pid: nspid[len(nspid)-1], startTime: stat.StartTime}] {
+			mapped = append(mapped, int32(hostPID))
+		}
+	}
+	return mapped, nil
+}
+
+// listProcessTree returns the root PID followed by PIDs of all its descendants
+// found in the proc directory
+func listProcessTree(procDir string, root int32) ([]int32, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	children := map[int32][]int32{}
+	for _, entry := range entries {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		// the process may be gone while scanning
+		stat, err := readProcStat(path.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		children[stat.PPID] = append(children[stat.PPID], int32(pid))
+	}
+	pids := []int32{root}
+	for i := 0; i < len(pids); i++ {
+		pids = append(pids, children[pids[i]]...)
+	}
+	return pids, nil
+}
+
+// listZombieChildren returns PIDs of children of the parent that have exited
+// and are not yet waited for
+func listZombieChildren(procDir string, parent int32) ([]int32, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int32
+	for _, entry := range entries {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		stat, err := readProcStat(path.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		if stat.PPID == parent && stat.State == "Z" {
+			pids = append(pids, int32(pid))
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+const (
+	EventPluginProfile datatype.EventType = "sys.plugin.profile"
+)
+
+// resourceProfileSchema is the version of the ResourceProfile format
+const resourceProfileSchema = 1
+
+// StartupTransient is the plugin's resource use right after it starts
+type StartupTransient struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	CPUCoresPeak    float64 `json:"cpu_cores_peak"`
+	MemoryPeakBytes float64 `json:"memory_peak_bytes"`
+}
+
+// ResourceProfile is the resource requirement of the plugin learned from its samples.
+// Steady-state values are taken after the startup transient. The memory peak is over
+// the whole run, including the startup transient
+type ResourceProfile struct {
+	Schema          int              `json:"schema"`
+	Plugin          string           `json:"plugin"`
+	ObservedSeconds float64          `json:"observed_seconds"`
+	CPUCoresSteady  float64          `json:"cpu_cores_steady"`
+	CPUCoresP95     float64          `json:"cpu_cores_p95"`
+	MemoryPeakBytes float64          `json:"memory_peak_bytes"`
+	MemoryP95Bytes  float64          `json:"memory_p95_bytes"`
+	GPUShareMean    float64          `json:"gpu_share_mean"`
+	GPUShareP95     float64          `json:"gpu_share_p95"`
+	Startup         StartupTransient `json:"startup"`
+	CPUSamples      int              `json:"cpu_samples"`
+	MemorySamples   int              `json:"memory_samples"`
+	GPUSamples      int              `json:"gpu_samples"`
+}
+
+// ResourceProfiler builds the plugin's resource profile from CPU, memory and GPU samples.
+// Samples within Warmup since the plugin started are the startup transient and
+// the rest are the steady state. It notifies the profile every Interval
+type ResourceProfiler struct {
+	Plugin    string
+	StartedAt time.Time
+	Warmup    time.Duration
+	Interval  time.Duration
+	Notifier  *interfacing.Notifier
+
+	mu      sync.Mutex
+	startup StartupTransient
+	cpu     *sampleReservoir
+	memory  *sampleReservoir
+	gpu     *sampleReservoir
+	lastT   time.Time
+	quit    chan struct{}
+	log     *slog.Logger
+}
+
+func NewResourceProfiler(c ControllerConfig, plugin string, startedAt time.Time) *ResourceProfiler {
+	interval := c.ProfileInterval
+	if interval < 1 {
+		interval = 1
+	}
+	return &ResourceProfiler{
+		Plugin:    plugin,
+		StartedAt: startedAt,
+		Warmup:    time.Duration(c.ProfileWarmup) * time.Second,
+		Interval:  time.Duration(interval) * time.Second,
+		Notifier:  interfacing.NewNotifier(),
+		cpu:       newSampleReservoir(reservoirSize),
+		memory:    newSampleReservoir(reservoirSize),
+		gpu:       newSampleReservoir(reservoirSize),
+		quit:      make(chan struct{}),
+		log:       componentLogger("profile"),
+	}
+}
+
+// Observe takes CPU, memory and GPU performance events into the profile
+func (p *ResourceProfiler) Observe(e datatype.Event) {
+	v, ok := eventValue(e)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := time.Unix(0, e.Timestamp)
+	if t.After(p.lastT) {
+		p.lastT = t
+	}
+	inStartup := t.Before(p.StartedAt.Add(p.Warmup))
+	switch e.Type {
+	case datatype.EventPluginPerfCPU:
+		cores := v / 100.
+		if inStartup {
+			p.startup.CPUCoresPeak = math.Max(p.startup.CPUCoresPeak, cores)
+		} else {
+			p.cpu.Add(cores)
+		}
+	case datatype.EventPluginPerfMem:
+		if inStartup {
+			p.startup.MemoryPeakBytes = math.Max(p.startup.MemoryPeakBytes, v)
+		} else {
+			p.memory.Add(v)
+		}
+	case datatype.EventPluginPerfGPU:
+		if !inStartup {
+			p.gpu.Add(v / 100.)
+		}
+	}
+}
+
+// Profile returns the profile learned so far
+func (p *ResourceProfiler) Profile() ResourceProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := ResourceProfile{
+		Schema:        resourceProfileSchema,
+		Plugin:        p.Plugin,
+		Startup:       p.startup,
+		CPUSamples:    p.cpu.Count(),
+		MemorySamples: p.memory.Count(),
+		GPUSamples:    p.gpu.Count(),
+	}
+	if p.lastT.After(p.StartedAt) {
+		r.ObservedSeconds = p.lastT.Sub(p.StartedAt).Seconds()
+	}
+	r.Startup.DurationSeconds = math.Min(p.Warmup.Seconds(), r.ObservedSeconds)
+	// the steady state is the typical use, so the median is taken over the mean
+	r.CPUCoresSteady = p.cpu.Percentile(50)
+	r.CPUCoresP95 = p.cpu.Percentile(95)
+	// the plugin needs its peak memory whenever it is reached
+	r.MemoryPeakBytes = math.Max(p.startup.MemoryPeakBytes, p.memory.Max())
+	r.MemoryP95Bytes = p.memory.Percentile(95)
+	r.GPUShareMean = p.gpu.Mean()
+	r.GPUShareP95 = p.gpu.Percentile(95)
+	return r
+}
+
+// ToEvent returns the profile as an event whose value is the JSON encoded profile
+func (r ResourceProfile) ToEvent() (datatype.Event, error) {
+	blob, err := json.Marshal(r)
+	if err != nil {
+		return datatype.Event{}, err
+	}
+	return datatype.NewEventBuilder(EventPluginProfile).
+		AddValue(string(blob)).
+		AddEntry("plugin", r.Plugin).
+		Build(), nil
+}
+
+func (p *ResourceProfiler) notifyProfile() {
+	e, err := p.Profile().ToEvent()
+	if err != nil {
+		p.log.Error("failed to encode resource profile", "error", err)
+		return
+	}
+	p.Notifier.Notify(e)
+}
+
+func (p *ResourceProfiler) Stop() {
+	p.quit <- struct{}{}
+}
+
+// Run notifies the profile every interval
+func (p *ResourceProfiler) Run() {
+	ticker := time.NewTicker(p.Interval)
+	for {
+		select {
+		case <-ticker.C:
+			p.notifyProfile()
+		case <-p.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
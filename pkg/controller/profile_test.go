@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestResourceProfiler(t *testing.T) {
+	startedAt := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	p := NewResourceProfiler(ControllerConfig{ProfileWarmup: 30, ProfileInterval: 300}, "app", startedAt)
+	// startup transient
+	p.Observe(newTestPerfEvent(datatype.EventPluginPerfCPU, startedAt.Add(10*time.Second), 350))
+	p.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, startedAt.Add(10*time.Second), 2000))
+	// steady state
+	for i, cpu := range []float64{100, 120, 80, 100, 100} {
+		at := startedAt.Add(time.Duration(40+i*10) * time.Second)
+		p.Observe(newTestPerfEvent(datatype.EventPluginPerfCPU, at, cpu))
+		p.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, at, float64(1000+i*100)))
+		p.Observe(newTestPerfEvent(datatype.EventPluginPerfGPU, at, 50))
+	}
+
+	r := p.Profile()
+	assert.Equal(t, r.Plugin, "app")
+	assert.Equal(t, r.ObservedSeconds, 80.)
+	assert.Equal(t, r.Startup.DurationSeconds, 30.)
+	assert.Equal(t, r.Startup.CPUCoresPeak, 3.5)
+	assert.Equal(t, r.Startup.MemoryPeakBytes, 2000.)
+	assert.Equal(t, r.CPUCoresSteady, 1.)
+	assert.Equal(t, r.CPUSamples, 5)
+	// the peak of the startup transient is the peak of the run
+	assert.Equal(t, r.MemoryPeakBytes, 2000.)
+	assert.Equal(t, r.MemoryP95Bytes, 1380.)
+	assert.Equal(t, r.GPUShareMean, 0.5)
+
+	e, err := r.ToEvent()
+	assert.NilError(t, err)
+	assert.Equal(t, e.Type, EventPluginProfile)
+	var decoded ResourceProfile
+	assert.NilError(t, json.Unmarshal([]byte(e.Meta["value"].(string)), &decoded))
+	assert.DeepEqual(t, decoded, r)
+}
+
+func TestResourceProfilerSamplesCPU(t *testing.T) {
+	// the profile consumes samples of the CPU collector without CPU performance logging
+	c := ControllerConfig{EnableResourceProfile: true}
+	assert.Assert(t, needsCPUCollector(c))
+	assert.Assert(t, needsCPUSamples(c))
+}
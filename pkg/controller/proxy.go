@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// maxMeasurementBytes limits the size of a request or a datagram to the publishing proxy
+const maxMeasurementBytes = 64 * 1024
+
+// Measurement is what an application publishes through the proxy, e.g.
+// {"name": "env.temperature", "value": 23.5, "timestamp": 1690000000000000000, "meta": {"sensor": "bme680"}}.
+// Timestamp is in nanoseconds and the time of receipt is taken if it is 0. Scope is
+// the publishing scope of the controller if empty and must be one of the scopes
+// configured for the controller otherwise
+type Measurement struct {
+	Name      string            `json:"name"`
+	Value     interface{}       `json:"value"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Scope     string            `json:"scope,omitempty"`
+}
+
+// messagePublisher publishes Waggle messages. It is implemented by Publisher
+type messagePublisher interface {
+	Publish(message *datatype.WaggleMessage, scope string) error
+	PublishAll(messages []*datatype.WaggleMessage, scopes []string) error
+}
+
+// PublishingProxy lets applications publish measurements without pywaggle. It accepts
+// a measurement or a list of measurements in JSON as a POST to /publish on HTTPAddress
+// and one measurement per line in a datagram on UDPAddress. Measurements are published
+// through the controller's RabbitMQ connection, so they carry the plugin's app ID
+type PublishingProxy struct {
+	HTTPAddress string
+	UDPAddress  string
+	Scope       string
+	scopes      map[string]bool
+	publisher   messagePublisher
+	metadata    Metadata
+	log         *slog.Logger
+}
+
+func NewPublishingProxy(c ControllerConfig, publisher messagePublisher, metadata Metadata) *PublishingProxy {
+	// measurements may go to the scopes that events of the controller go to
+	scopes := map[string]bool{
+		c.MetricsPublishingScope: true,
+		localPublishingScope:     true,
+	}
+	for _, r := range c.PublishingRoutes {
+		if r.Scope != dropPublishingScope {
+			scopes[r.Scope] = true
+		}
+	}
+	return &PublishingProxy{
+		HTTPAddress: c.PublishingProxyAddress,
+		UDPAddress:  c.PublishingProxyUDPAddress,
+		Scope:       c.MetricsPublishingScope,
+		scopes:      scopes,
+		publisher:   publisher,
+		metadata:    metadata,
+		log:         componentLogger("proxy"),
+	}
+}
+
+// decodeMeasurements decodes a measurement or a list of measurements. Integers are
+// kept as integers
+func decodeMeasurements(body []byte) ([]Measurement, error) {
+	body = bytes.TrimSpace(body)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var measurements []Measurement
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := decoder.Decode(&measurements); err != nil {
+			return nil, err
+		}
+		return measurements, nil
+	}
+	var m Measurement
+	if err := decoder.Decode(&m); err != nil {
+		return nil, err
+	}
+	return []Measurement{m}, nil
+}
+
+// ToWaggleMessage validates the measurement and returns it as a Waggle message.
+// Meta of the measurement takes precedence over the metadata
+func (m Measurement) ToWaggleMessage(metadata Metadata, now time.Time) (*datatype.WaggleMessage, error) {
+	if m.Name == "" {
+		return nil, fmt.Errorf("measurement must have a name")
+	}
+	if !measurementNamePattern.MatchString(m.Name) {
+		return nil, fmt.Errorf("invalid measurement name %q", m.Name)
+	}
+	var value interface{}
+	switch v := m.Value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			value = i
+		} else if f, err := v.Float64(); err == nil {
+			value = f
+		} else {
+			return nil, fmt.Errorf("invalid value of %s: %s", m.Name, v)
+		}
+	case string, int, int64, float64:
+		value = v
+	default:
+		return nil, fmt.Errorf("value of %s must be a number or a string", m.Name)
+	}
+	timestamp := m.Timestamp
+	if timestamp == 0 {
+		timestamp = now.UnixNano()
+	}
+	meta := map[string]string{}
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	for k, v := range m.Meta {
+		meta[k] = v
+	}
+	return datatype.NewMessage(m.Name, value, timestamp, meta), nil
+}
+
+// scope returns the scope of the measurement. A scope that is not configured is refused
+func (p *PublishingProxy) scope(m Measurement) (string, error) {
+	if m.Scope == "" {
+		return p.Scope, nil
+	}
+	if !p.scopes[m.Scope] {
+		return "", fmt.Errorf("scope %q of %s is not allowed", m.Scope, m.Name)
+	}
+	return m.Scope, nil
+}
+
+// Publish queues the measurement to publish
+func (p *PublishingProxy) Publish(m Measurement, protocol string) error {
+	msg, err := m.ToWaggleMessage(p.metadata, time.Now())
+	if err != nil {
+		proxyMeasurements.WithLabelValues(protocol, "invalid").Inc()
+		return err
+	}
+	scope, err := p.scope(m)
+	if err != nil {
+		proxyMeasurements.WithLabelValues(protocol, "invalid").Inc()
+		return err
+	}
+	if err := p.publisher.Publish(msg, scope); err != nil {
+		proxyMeasurements.WithLabelValues(protocol, "dropped").Inc()
+		return err
+	}
+	proxyMeasurements.WithLabelValues(protocol, "published").Inc()
+	return nil
+}
+
+func (p *PublishingProxy) handlerPublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMeasurementBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	measurements, err := decodeMeasurements(body)
+	if err != nil {
+		proxyMeasurements.WithLabelValues("http", "invalid").Inc()
+		http.Error(w, fmt.Sprintf("failed to parse measurements: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	// measurements are validated before any of them is published, and all of them
+	// are queued or none is, so a retry of the request does not publish any of them twice
+	now := time.Now()
+	messages := make([]*datatype.WaggleMessage, 0, len(measurements))
+	scopes := make([]string, 0, len(measurements))
+	for _, m := range measurements {
+		msg, err := m.ToWaggleMessage(p.metadata, now)
+		if err != nil {
+			proxyMeasurements.WithLabelValues("http", "invalid").Inc()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scope, err := p.scope(m)
+		if err != nil {
+			proxyMeasurements.WithLabelValues("http", "invalid").Inc()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages = append(messages, msg)
+		scopes = append(scopes, scope)
+	}
+	if err := p.publisher.PublishAll(messages, scopes); err != nil {
+		proxyMeasurements.WithLabelValues("http", "dropped").Add(float64(len(messages)))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	proxyMeasurements.WithLabelValues("http", "published").Add(float64(len(messages)))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"published": %d}`+"\n", len(measurements))
+}
+
+// serveUDP publishes measurements of datagrams. A datagram has a measurement per line
+func (p *PublishingProxy) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxMeasurementBytes)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			measurements, err := decodeMeasurements(line)
+			if err != nil {
+				proxyMeasurements.WithLabelValues("udp", "invalid").Inc()
+				p.log.Warn("failed to parse measurement", "remote_addr", addr.String(), "error", err)
+				continue
+			}
+			for _, m := range measurements {
+				if err := p.Publish(m, "udp"); err != nil {
+					p.log.Warn("failed to publish measurement", "name", m.Name, "remote_addr", addr.String(), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// Run serves the HTTP endpoint and the UDP endpoint if its address is given
+func (p *PublishingProxy) Run() {
+	if p.UDPAddress != "" {
+		conn, err := net.ListenPacket("udp", p.UDPAddress)
+		if err != nil {
+			p.log.Error("failed to listen on UDP", "address", p.UDPAddress, "error", err)
+		} else {
+			p.log.Info("publishing proxy listens on UDP", "address", p.UDPAddress)
+			go func() {
+				if err := p.serveUDP(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+					p.log.Error("UDP publishing proxy stopped", "error", err)
+				}
+			}()
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publish", p.handlerPublish)
+	p.log.Info("publishing proxy starts", "address", p.HTTPAddress)
+	if err := http.ListenAndServe(p.HTTPAddress, accessLog(p.log, mux)); err != nil {
+		p.log.Error("publishing proxy stopped", "error", err)
+	}
+}
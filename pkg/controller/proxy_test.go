@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+type testPublisher struct {
+	mu       sync.Mutex
+	messages []*datatype.WaggleMessage
+	scopes   []string
+	capacity int
+}
+
+func (p *testPublisher) Publish(message *datatype.WaggleMessage, scope string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.messages) >= p.capacity {
+		return fmt.Errorf("maximum capacity (%d) reached", p.capacity)
+	}
+	p.messages = append(p.messages, message)
+	p.scopes = append(p.scopes, scope)
+	return nil
+}
+
+func (p *testPublisher) PublishAll(messages []*datatype.WaggleMessage, scopes []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.messages)+len(messages) > p.capacity {
+		return fmt.Errorf("maximum capacity (%d) reached", p.capacity)
+	}
+	p.messages = append(p.messages, messages...)
+	p.scopes = append(p.scopes, scopes...)
+	return nil
+}
+
+func (p *testPublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.messages)
+}
+
+func TestMeasurementToWaggleMessage(t *testing.T) {
+	now := time.Unix(1690000000, 0)
+	measurements, err := decodeMeasurements([]byte(`[
+		{"name": "env.count", "value": 3},
+		{"name": "env.temperature", "value": 23.5, "timestamp": 1690000001000000000, "meta": {"sensor": "bme680"}},
+		{"name": "env.label", "value": "cloudy"}
+	]`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(measurements), 3)
+
+	msg, err := measurements[0].ToWaggleMessage(Metadata{"plugin_name": "test-pipeline", "sensor": "default"}, now)
+	assert.NilError(t, err)
+	assert.Equal(t, msg.Value, int64(3))
+	assert.Equal(t, msg.Timestamp, now.UnixNano())
+	assert.Equal(t, msg.Meta["sensor"], "default")
+
+	msg, err = measurements[1].ToWaggleMessage(Metadata{"plugin_name": "test-pipeline", "sensor": "default"}, now)
+	assert.NilError(t, err)
+	assert.Equal(t, msg.Value, 23.5)
+	assert.Equal(t, msg.Timestamp, int64(1690000001000000000))
+	assert.DeepEqual(t, msg.Meta, map[string]string{"plugin_name": "test-pipeline", "sensor": "bme680"})
+
+	msg, err = measurements[2].ToWaggleMessage(nil, now)
+	assert.NilError(t, err)
+	assert.Equal(t, msg.Value, "cloudy")
+
+	_, err = Measurement{Value: 1}.ToWaggleMessage(nil, now)
+	assert.ErrorContains(t, err, "must have a name")
+	for _, name := range []string{"env count", "env..count", ".env", "env.count.", "env/count"} {
+		_, err = Measurement{Name: name, Value: 1}.ToWaggleMessage(nil, now)
+		assert.ErrorContains(t, err, "invalid measurement name")
+	}
+	measurements, err = decodeMeasurements([]byte(`{"name": "env.flag", "value": true}`))
+	assert.NilError(t, err)
+	_, err = measurements[0].ToWaggleMessage(nil, now)
+	assert.ErrorContains(t, err, "number or a string")
+	_, err = decodeMeasurements([]byte(`{"name": "env.count", "val": 1}`))
+	assert.ErrorContains(t, err, "unknown field")
+}
+
+func TestPublishingProxyHTTP(t *testing.T) {
+	publisher := &testPublisher{capacity: 4}
+	p := NewPublishingProxy(ControllerConfig{MetricsPublishingScope: "beehive"}, publisher, Metadata{"plugin_name": "test-pipeline", "sensor": "default"})
+	for _, tc := range []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPost, `{"name": "env.count", "value": 3}`, http.StatusAccepted},
+		{http.MethodPost, `[{"name": "env.count", "value": 4}, {"name": "env.count", "value": 5, "scope": "node"}]`, http.StatusAccepted},
+		{http.MethodPost, `[{"name": "env.count", "value": 6}, {"value": 7}]`, http.StatusBadRequest},
+		// only the configured scopes are allowed
+		{http.MethodPost, `[{"name": "env.count", "value": 6}, {"name": "env.count", "value": 7, "scope": "all-nodes"}]`, http.StatusBadRequest},
+		{http.MethodPost, `{"name": "env.count", "value": 6, "scope": "none"}`, http.StatusBadRequest},
+		{http.MethodPost, `not json`, http.StatusBadRequest},
+		{http.MethodGet, ``, http.StatusMethodNotAllowed},
+		// the publisher has room for one of the batch, so none of the batch is published
+		{http.MethodPost, `[{"name": "env.count", "value": 8}, {"name": "env.count", "value": 9}]`, http.StatusServiceUnavailable},
+	} {
+		rr := httptest.NewRecorder()
+		p.handlerPublish(rr, httptest.NewRequest(tc.method, "/publish", strings.NewReader(tc.body)))
+		assert.Equal(t, rr.Code, tc.status, tc.body)
+	}
+	assert.Equal(t, publisher.count(), 3)
+	assert.DeepEqual(t, publisher.scopes, []string{"beehive", "beehive", "node"})
+	rr := httptest.NewRecorder()
+	p.handlerPublish(rr, httptest.NewRequest(http.MethodPost, "/publish", strings.NewReader(`{"name": "env.count", "value": 10}`)))
+	assert.Equal(t, rr.Code, http.StatusAccepted)
+	assert.Equal(t, publisher.count(), 4)
+	assert.Equal(t, publisher.messages[3].Value, int64(10))
+	assert.Equal(t, publisher.messages[0].Meta["plugin_name"], "test-pipeline")
+}
+
+func TestPublishingProxyUDP(t *testing.T) {
+	publisher := &testPublisher{capacity: 10}
+	p := NewPublishingProxy(ControllerConfig{
+		MetricsPublishingScope: "beehive",
+		PublishingRoutes:       PublishingRoutes{{EventType: "env.*", Scope: "lab"}},
+	}, publisher, Metadata{"plugin_name": "test-pipeline", "sensor": "default"})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer conn.Close()
+	go p.serveUDP(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	assert.NilError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("{\"name\": \"env.count\", \"value\": 1}\nnot json\n{\"name\": \"env.count\", \"value\": 0, \"scope\": \"all-nodes\"}\n{\"name\": \"env.count\", \"value\": 2, \"scope\": \"lab\"}\n"))
+	assert.NilError(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for publisher.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, publisher.count(), 2)
+	assert.Equal(t, publisher.messages[1].Value, int64(2))
+	// the scope of a route is allowed
+	assert.DeepEqual(t, publisher.scopes, []string{"beehive", "lab"})
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// waggleSender sends Waggle messages to RabbitMQ. It is implemented by RabbitMQHandler
+type waggleSender interface {
+	SendWaggleMessageOnNode(message *datatype.WaggleMessage, scope string) error
+}
+
+// publishRequest is a message to publish or a call to run. A synchronous request
+// carries done to receive the result
+type publishRequest struct {
+	message *datatype.WaggleMessage
+	scope   string
+	call    func() error
+	done    chan error
+}
+
+// Publisher sends Waggle messages to RabbitMQ in the background. Unlike
+// RabbitMQHandler.StartLoop, it counts messages that fail to be published.
+// RabbitMQHandler is not safe for concurrent use, so every message is sent by Run.
+// Messages are queued one producer at a time, so room checked for a batch stays free
+type Publisher struct {
+	rmq   waggleSender
+	mu    sync.Mutex
+	queue chan publishRequest
+	log   *slog.Logger
+}
+
+func NewPublisher(rmq waggleSender, queueSize int) *Publisher {
+	return &Publisher{
+		rmq:   rmq,
+		queue: make(chan publishRequest, queueSize),
+		log:   componentLogger("publisher"),
+	}
+}
+
+// QueueDepth returns the number of messages waiting to be published
+func (p *Publisher) QueueDepth() float64 {
+	if p == nil {
+		return 0
+	}
+	return float64(len(p.queue))
+}
+
+// Publish queues the message. The message is dropped if the queue is full
+func (p *Publisher) Publish(message *datatype.WaggleMessage, scope string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case p.queue <- publishRequest{message: message, scope: scope}:
+		return nil
+	default:
+		rabbitMQPublishFailures.Inc()
+		return fmt.Errorf("maximum capacity (%d) reached. this message will not be cached", cap(p.queue))
+	}
+}
+
+// PublishSync sends the message after the messages already queued and waits for
+// the result. It requires Run to be running
+func (p *Publisher) PublishSync(message *datatype.WaggleMessage, scope string) error {
+	done := make(chan error, 1)
+	p.mu.Lock()
+	p.queue <- publishRequest{message: message, scope: scope, done: done}
+	p.mu.Unlock()
+	return <-done
+}
+
+// Do runs the call by Run after the messages already queued and waits for its result.
+// Other users of RabbitMQHandler go through Do, so the handler is used by Run only
+func (p *Publisher) Do(call func() error) error {
+	done := make(chan error, 1)
+	p.mu.Lock()
+	p.queue <- publishRequest{call: call, done: done}
+	p.mu.Unlock()
+	return <-done
+}
+
+// PublishAll queues the messages with their scopes. None of the messages is queued
+// if the queue does not have room for all of them
+func (p *Publisher) PublishAll(messages []*datatype.WaggleMessage, scopes []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if room := cap(p.queue) - len(p.queue); room < len(messages) {
+		rabbitMQPublishFailures.Add(float64(len(messages)))
+		return fmt.Errorf("maximum capacity (%d) reached. room for %d of %d messages, none of them will be cached", cap(p.queue), room, len(messages))
+	}
+	for i, message := range messages {
+		p.queue <- publishRequest{message: message, scope: scopes[i]}
+	}
+	return nil
+}
+
+func (p *Publisher) send(message *datatype.WaggleMessage, scope string) error {
+	if err := p.rmq.SendWaggleMessageOnNode(message, scope); err != nil {
+		rabbitMQPublishFailures.Inc()
+		return err
+	}
+	rabbitMQPublished.Inc()
+	return nil
+}
+
+func (p *Publisher) Run() {
+	for r := range p.queue {
+		if r.call != nil {
+			r.done <- r.call()
+			continue
+		}
+		err := p.send(r.message, r.scope)
+		if r.done != nil {
+			r.done <- err
+		} else if err != nil {
+			p.log.Error("failed to publish", "name", r.message.Name, "scope", r.scope, "error", err)
+		}
+	}
+}
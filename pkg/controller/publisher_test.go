@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+// testSender records sent messages. Sending fails while failing is set
+type testSender struct {
+	mu      sync.Mutex
+	failing bool
+	sent    []string
+}
+
+func (s *testSender) SendWaggleMessageOnNode(message *datatype.WaggleMessage, scope string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return fmt.Errorf("connection refused")
+	}
+	s.sent = append(s.sent, message.Name+"@"+scope)
+	return nil
+}
+
+func TestPublisherQueue(t *testing.T) {
+	p := NewPublisher(&testSender{}, 2)
+	assert.Equal(t, p.QueueDepth(), 0.)
+	failures := testutil.ToFloat64(rabbitMQPublishFailures)
+	assert.NilError(t, p.Publish(datatype.NewMessage("sys.plugin.perf.cpu", 1., 0, nil), "node"))
+	assert.NilError(t, p.Publish(datatype.NewMessage("sys.plugin.perf.mem", 1., 0, nil), "node"))
+	assert.Equal(t, p.QueueDepth(), 2.)
+	assert.ErrorContains(t, p.Publish(datatype.NewMessage("sys.plugin.perf.gpu", 1., 0, nil), "node"), "maximum capacity (2) reached")
+	assert.Equal(t, testutil.ToFloat64(rabbitMQPublishFailures)-failures, 1.)
+
+	// a batch is queued only as a whole
+	p = NewPublisher(&testSender{}, 3)
+	assert.NilError(t, p.Publish(datatype.NewMessage("sys.plugin.perf.cpu", 1., 0, nil), "node"))
+	batch := []*datatype.WaggleMessage{
+		datatype.NewMessage("env.count", 1, 0, nil),
+		datatype.NewMessage("env.count", 2, 0, nil),
+		datatype.NewMessage("env.count", 3, 0, nil),
+	}
+	assert.ErrorContains(t, p.PublishAll(batch, []string{"node", "node", "node"}), "room for 2 of 3 messages")
+	assert.Equal(t, p.QueueDepth(), 1.)
+	assert.NilError(t, p.PublishAll(batch[:2], []string{"node", "beehive"}))
+	assert.Equal(t, p.QueueDepth(), 3.)
+
+	var nilPublisher *Publisher
+	assert.Equal(t, nilPublisher.QueueDepth(), 0.)
+}
+
+func TestPublisherPublishSync(t *testing.T) {
+	sender := &testSender{}
+	p := NewPublisher(sender, 10)
+	assert.NilError(t, p.Publish(datatype.NewMessage("sys.plugin.perf.cpu", 1., 0, nil), "node"))
+	go p.Run()
+	// the synchronous message goes after the queued one from the same goroutine
+	assert.NilError(t, p.PublishSync(datatype.NewMessage("sys.plugin.perf.summary", "{}", 0, nil), "beehive"))
+	sender.mu.Lock()
+	assert.DeepEqual(t, sender.sent, []string{"sys.plugin.perf.cpu@node", "sys.plugin.perf.summary@beehive"})
+	sender.failing = true
+	sender.mu.Unlock()
+	assert.ErrorContains(t, p.PublishSync(datatype.NewMessage("sys.plugin.exit", 0, 0, nil), "beehive"), "connection refused")
+	assert.Equal(t, p.QueueDepth(), 0.)
+
+	// a call runs after the queued messages and returns its result
+	sender.mu.Lock()
+	sender.failing = false
+	sender.mu.Unlock()
+	assert.NilError(t, p.Publish(datatype.NewMessage("sys.plugin.perf.mem", 1., 0, nil), "node"))
+	assert.ErrorContains(t, p.Do(func() error {
+		sender.mu.Lock()
+		defer sender.mu.Unlock()
+		assert.Equal(t, sender.sent[len(sender.sent)-1], "sys.plugin.perf.mem@node")
+		return fmt.Errorf("channel is closed")
+	}), "channel is closed")
+}
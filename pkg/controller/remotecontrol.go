@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+	"gopkg.in/cenkalti/backoff.v1"
+)
+
+const (
+	ControlCommandSetInterval      = "set_interval"
+	ControlCommandEnableCollector  = "enable_collector"
+	ControlCommandDisableCollector = "disable_collector"
+	ControlCommandPause            = "pause"
+	ControlCommandResume           = "resume"
+	ControlCommandStatus           = "status"
+)
+
+// sampler is a collector that samples periodically. It can be stopped and run again
+type sampler interface {
+	Run()
+	Stop()
+	setInterval(seconds int)
+}
+
+// ControlMessage is a command sent to the controller, e.g.
+// {"correlation_id": "1", "command": "set_interval", "interval": 10}
+type ControlMessage struct {
+	CorrelationID string `json:"correlation_id"`
+	Command       string `json:"command"`
+	Interval      int    `json:"interval,omitempty"`
+	Collector     string `json:"collector,omitempty"`
+}
+
+// ControlReply is the result of a command. It carries the correlation ID of the command
+type ControlReply struct {
+	CorrelationID string                 `json:"correlation_id"`
+	Command       string                 `json:"command"`
+	Result        string                 `json:"result"`
+	Error         string                 `json:"error,omitempty"`
+	Status        map[string]interface{} `json:"status,omitempty"`
+}
+
+// controlQueue is where control messages are consumed from and replied to
+type controlQueue interface {
+	Consume(exchange string, queue string, topic string) (<-chan amqp.Delivery, error)
+	Reply(d amqp.Delivery, body []byte) error
+}
+
+// rmqControlQueue consumes control messages on the connection of RabbitMQHandler.
+// The handler is not safe for concurrent use, so it is used through the publisher
+type rmqControlQueue struct {
+	rmq       *interfacing.RabbitMQHandler
+	publisher *Publisher
+}
+
+func (q *rmqControlQueue) Consume(exchange string, queue string, topic string) (<-chan amqp.Delivery, error) {
+	var deliveries <-chan amqp.Delivery
+	err := q.publisher.Do(func() error {
+		declared, err := q.rmq.DeclareQueueAndConnectToExchange(exchange, queue, topic)
+		if err == nil {
+			deliveries, err = q.rmq.GetReceiver(declared.Name)
+		}
+		if err != nil {
+			// a failure, e.g. binding to an exchange that does not exist yet, closes the
+			// channel of the handler. The handler only connects again when its connection
+			// is closed, so it is connected here to keep publishing
+			if connectErr := q.rmq.Connect(); connectErr != nil {
+				return fmt.Errorf("%s. failed to connect again: %s", err.Error(), connectErr.Error())
+			}
+		}
+		return err
+	})
+	return deliveries, err
+}
+
+// Reply sends the reply to the queue of ReplyTo of the message with its correlation ID.
+// The reply goes on the channel that the message was delivered on
+func (q *rmqControlQueue) Reply(d amqp.Delivery, body []byte) error {
+	ch, ok := d.Acknowledger.(*amqp.Channel)
+	if !ok {
+		return fmt.Errorf("message is not delivered on a channel")
+	}
+	return q.publisher.Do(func() error {
+		return ch.Publish("", d.ReplyTo, false, false, amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Body:          body,
+		})
+	})
+}
+
+// RemoteControl consumes control messages from a RabbitMQ queue bound to Exchange with Topic.
+// The exchange is owned by the sender, e.g. the edge-scheduler. A reply to a message is
+// sent to the queue of its ReplyTo with its correlation ID
+type RemoteControl struct {
+	Exchange string
+	Queue    string
+	Topic    string
+
+	queue         controlQueue
+	pluginControl *PluginControl
+	version       string
+	interval      int
+	samplers      map[string]sampler
+	running       map[string]bool
+	log           *slog.Logger
+}
+
+func NewRemoteControl(c ControllerConfig, rmq *interfacing.RabbitMQHandler, publisher *Publisher, pluginControl *PluginControl) *RemoteControl {
+	topic := c.ControlTopic
+	if topic == "" {
+		topic = c.RabbitMQAppID
+	}
+	queue := c.ControlQueue
+	if queue == "" {
+		queue = "plugin-controller." + topic
+	}
+	return &RemoteControl{
+		Exchange:      c.ControlExchange,
+		Queue:         queue,
+		Topic:         topic,
+		queue:         &rmqControlQueue{rmq: rmq, publisher: publisher},
+		pluginControl: pluginControl,
+		version:       c.Version,
+		interval:      c.PerformanceCollectionInterval,
+		samplers:      map[string]sampler{},
+		running:       map[string]bool{},
+		log:           componentLogger("remote_control"),
+	}
+}
+
+// AddSampler makes the sampler controllable by its name. The sampler must be running
+func (rc *RemoteControl) AddSampler(name string, s sampler) {
+	rc.samplers[name] = s
+	rc.running[name] = true
+}
+
+func (rc *RemoteControl) status() map[string]interface{} {
+	collectors := map[string]bool{}
+	for name := range rc.samplers {
+		collectors[name] = rc.running[name]
+	}
+	status := map[string]interface{}{
+		"version":    rc.version,
+		"interval":   rc.interval,
+		"collectors": collectors,
+	}
+	if rc.pluginControl != nil {
+		status["plugin_pid"] = rc.pluginControl.proc.Pid
+	}
+	return status
+}
+
+func (rc *RemoteControl) setInterval(seconds int) error {
+	if seconds < 1 {
+		return fmt.Errorf("interval must be at least 1 second: %d", seconds)
+	}
+	names := make([]string, 0, len(rc.samplers))
+	for name := range rc.samplers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := rc.samplers[name]
+		if rc.running[name] {
+			s.Stop()
+			s.setInterval(seconds)
+			go s.Run()
+		} else {
+			s.setInterval(seconds)
+		}
+	}
+	rc.interval = seconds
+	return nil
+}
+
+func (rc *RemoteControl) setCollector(name string, enable bool) error {
+	s, found := rc.samplers[name]
+	if !found {
+		return fmt.Errorf("unknown collector %q", name)
+	}
+	switch {
+	case enable && !rc.running[name]:
+		go s.Run()
+	case !enable && rc.running[name]:
+		s.Stop()
+	}
+	rc.running[name] = enable
+	return nil
+}
+
+// Handle runs the command and returns its reply
+func (rc *RemoteControl) Handle(m ControlMessage) ControlReply {
+	reply := ControlReply{
+		CorrelationID: m.CorrelationID,
+		Command:       m.Command,
+	}
+	var err error
+	switch m.Command {
+	case ControlCommandSetInterval:
+		err = rc.setInterval(m.Interval)
+	case ControlCommandEnableCollector:
+		err = rc.setCollector(m.Collector, true)
+	case ControlCommandDisableCollector:
+		err = rc.setCollector(m.Collector, false)
+	case ControlCommandPause:
+		_, err = rc.pluginControl.Pause()
+	case ControlCommandResume:
+		_, err = rc.pluginControl.Resume()
+	case ControlCommandStatus:
+	default:
+		err = fmt.Errorf("unknown command %q", m.Command)
+	}
+	if err != nil {
+		reply.Result = "failed"
+		reply.Error = err.Error()
+	} else {
+		reply.Result = "success"
+		reply.Status = rc.status()
+	}
+	return reply
+}
+
+// reply sends the reply to the sender of the message. A message without ReplyTo
+// does not expect a reply
+func (rc *RemoteControl) reply(d amqp.Delivery, reply ControlReply) {
+	if d.ReplyTo == "" {
+		rc.log.Debug("control message without reply_to is not replied", "correlation_id", reply.CorrelationID)
+		return
+	}
+	blob, err := json.Marshal(reply)
+	if err != nil {
+		rc.log.Error("failed to encode reply", "correlation_id", reply.CorrelationID, "error", err)
+		return
+	}
+	if err := rc.queue.Reply(d, blob); err != nil {
+		rc.log.Error("failed to reply", "correlation_id", reply.CorrelationID, "reply_to", d.ReplyTo, "error", err)
+	}
+}
+
+// consume handles messages from the queue until the queue is closed
+func (rc *RemoteControl) consume() error {
+	deliveries, err := rc.queue.Consume(rc.Exchange, rc.Queue, rc.Topic)
+	if err != nil {
+		return err
+	}
+	rc.log.Info("consuming control messages", "exchange", rc.Exchange, "queue", rc.Queue, "topic", rc.Topic)
+	for d := range deliveries {
+		var m ControlMessage
+		if err := json.Unmarshal(d.Body, &m); err != nil {
+			rc.reply(d, ControlReply{
+				CorrelationID: d.CorrelationId,
+				Result:        "failed",
+				Error:         fmt.Sprintf("failed to parse control message: %s", err.Error()),
+			})
+			continue
+		}
+		// the AMQP correlation ID is used if the message does not carry one
+		if m.CorrelationID == "" {
+			m.CorrelationID = d.CorrelationId
+		}
+		rc.log.Info("control message received", "correlation_id", m.CorrelationID, "command", m.Command)
+		rc.reply(d, rc.Handle(m))
+	}
+	return fmt.Errorf("control queue %s is closed", rc.Queue)
+}
+
+// Run consumes control messages. It reconnects when the connection is lost
+func (rc *RemoteControl) Run() {
+	for {
+		if err := backoff.Retry(rc.consume, backoff.NewExponentialBackOff()); err != nil {
+			rc.log.Error("failed to consume control messages", "error", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"gotest.tools/v3/assert"
+)
+
+type testSampler struct {
+	runs     chan struct{}
+	quit     chan struct{}
+	interval int
+}
+
+func (s *testSampler) Run() {
+	s.runs <- struct{}{}
+	<-s.quit
+}
+
+func (s *testSampler) Stop() {
+	s.quit <- struct{}{}
+}
+
+func (s *testSampler) setInterval(seconds int) {
+	s.interval = seconds
+}
+
+func TestRemoteControl(t *testing.T) {
+	rc := NewRemoteControl(ControllerConfig{RabbitMQAppID: "app", PerformanceCollectionInterval: 5}, nil, nil, nil)
+	assert.Equal(t, rc.Topic, "app")
+	assert.Equal(t, rc.Queue, "plugin-controller.app")
+	s := &testSampler{runs: make(chan struct{}, 10), quit: make(chan struct{})}
+	go s.Run()
+	<-s.runs
+	rc.AddSampler("cpu", s)
+
+	reply := rc.Handle(ControlMessage{CorrelationID: "1", Command: ControlCommandSetInterval, Interval: 10})
+	assert.Equal(t, reply.CorrelationID, "1")
+	assert.Equal(t, reply.Result, "success")
+	assert.Equal(t, reply.Status["interval"], 10)
+	// the sampler is restarted with the new interval
+	<-s.runs
+	assert.Equal(t, s.interval, 10)
+
+	reply = rc.Handle(ControlMessage{CorrelationID: "2", Command: ControlCommandDisableCollector, Collector: "cpu"})
+	assert.Equal(t, reply.Result, "success")
+	assert.DeepEqual(t, reply.Status["collectors"], map[string]bool{"cpu": false})
+
+	reply = rc.Handle(ControlMessage{CorrelationID: "3", Command: ControlCommandEnableCollector, Collector: "cpu"})
+	assert.Equal(t, reply.Result, "success")
+	<-s.runs
+
+	reply = rc.Handle(ControlMessage{CorrelationID: "4", Command: ControlCommandEnableCollector, Collector: "gpu"})
+	assert.Equal(t, reply.Result, "failed")
+	assert.Assert(t, strings.Contains(reply.Error, "unknown collector"))
+
+	reply = rc.Handle(ControlMessage{CorrelationID: "5", Command: ControlCommandSetInterval, Interval: 0})
+	assert.Equal(t, reply.Result, "failed")
+
+	reply = rc.Handle(ControlMessage{CorrelationID: "6", Command: "reboot"})
+	assert.Assert(t, strings.Contains(reply.Error, "unknown command"))
+}
+
+// testControlQueue fails to declare the queue until the exchange is created
+// and records replies
+type testControlQueue struct {
+	exchangeCreated bool
+	deliveries      chan amqp.Delivery
+	replies         []amqp.Delivery
+	bodies          []string
+}
+
+func (q *testControlQueue) Consume(exchange string, queue string, topic string) (<-chan amqp.Delivery, error) {
+	if !q.exchangeCreated {
+		return nil, errors.New(`Exception (404) Reason: "NOT_FOUND - no exchange 'plugin-control' in vhost '/'"`)
+	}
+	return q.deliveries, nil
+}
+
+func (q *testControlQueue) Reply(d amqp.Delivery, body []byte) error {
+	q.replies = append(q.replies, d)
+	q.bodies = append(q.bodies, string(body))
+	return nil
+}
+
+func TestRemoteControlConsume(t *testing.T) {
+	rc := NewRemoteControl(ControllerConfig{RabbitMQAppID: "app", ControlExchange: "plugin-control"}, nil, nil, nil)
+	q := &testControlQueue{deliveries: make(chan amqp.Delivery, 3)}
+	rc.queue = q
+	assert.ErrorContains(t, rc.consume(), "NOT_FOUND")
+
+	q.exchangeCreated = true
+	q.deliveries <- amqp.Delivery{CorrelationId: "7", ReplyTo: "amq.gen-reply", Body: []byte(`{"command": "status"}`)}
+	q.deliveries <- amqp.Delivery{CorrelationId: "8", ReplyTo: "amq.gen-reply", Body: []byte(`not json`)}
+	// a message without ReplyTo is handled without a reply
+	q.deliveries <- amqp.Delivery{CorrelationId: "9", Body: []byte(`{"command": "status"}`)}
+	close(q.deliveries)
+	assert.ErrorContains(t, rc.consume(), "is closed")
+
+	assert.Equal(t, len(q.replies), 2)
+	// the reply goes to ReplyTo of its message with the correlation ID of the message
+	assert.Equal(t, q.replies[0].ReplyTo, "amq.gen-reply")
+	assert.Equal(t, q.replies[0].CorrelationId, "7")
+	var reply ControlReply
+	assert.NilError(t, json.Unmarshal([]byte(q.bodies[0]), &reply))
+	assert.Equal(t, reply.CorrelationID, "7")
+	assert.Equal(t, reply.Result, "success")
+	assert.NilError(t, json.Unmarshal([]byte(q.bodies[1]), &reply))
+	assert.Equal(t, reply.CorrelationID, "8")
+	assert.Equal(t, reply.Result, "failed")
+	assert.Assert(t, strings.Contains(reply.Error, "failed to parse control message"))
+}
+
+func TestRMQControlQueueReply(t *testing.T) {
+	// a reply needs the channel that the message was delivered on
+	q := &rmqControlQueue{}
+	assert.ErrorContains(t, q.Reply(amqp.Delivery{ReplyTo: "amq.gen-reply"}, []byte("{}")), "not delivered on a channel")
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// reservoirSize is the number of samples that a reservoir keeps to estimate percentiles
+const reservoirSize = 1024
+
+// sampleReservoir keeps a uniform random sample of up to size values, so percentiles
+// of a long run are estimated in bounded memory. Percentiles are exact until more than
+// size values are added. The count, mean and maximum are always exact
+type sampleReservoir struct {
+	size    int
+	samples []float64
+	count   int
+	sum     float64
+	max     float64
+	rand    *rand.Rand
+}
+
+func newSampleReservoir(size int) *sampleReservoir {
+	return &sampleReservoir{
+		size: size,
+		max:  math.Inf(-1),
+		rand: rand.New(rand.NewSource(1)),
+	}
+}
+
+// Add adds the value. Once the reservoir is full, the value replaces a random sample
+// with the probability of size over the number of values added
+func (r *sampleReservoir) Add(v float64) {
+	r.count += 1
+	r.sum += v
+	r.max = math.Max(r.max, v)
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, v)
+		return
+	}
+	if i := r.rand.Intn(r.count); i < r.size {
+		r.samples[i] = v
+	}
+}
+
+// Count returns the number of values added
+func (r *sampleReservoir) Count() int {
+	return r.count
+}
+
+// Mean returns the mean of the values added or 0 if none is added
+func (r *sampleReservoir) Mean() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	return r.sum / float64(r.count)
+}
+
+// Max returns the largest value added or 0 if none is added
+func (r *sampleReservoir) Max() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	return r.max
+}
+
+// Percentile returns the p-th percentile of the samples
+func (r *sampleReservoir) Percentile(p float64) float64 {
+	sorted := make([]float64, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Float64s(sorted)
+	return percentile(sorted, p)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"math"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSampleReservoir(t *testing.T) {
+	r := newSampleReservoir(100)
+	assert.Equal(t, r.Max(), 0.)
+	assert.Equal(t, r.Percentile(95), 0.)
+	for i := 1; i <= 10; i++ {
+		r.Add(float64(i))
+	}
+	// percentiles are exact until the reservoir is full
+	assert.Equal(t, r.Percentile(50), 5.5)
+	assert.Equal(t, r.Mean(), 5.5)
+	assert.Equal(t, r.Max(), 10.)
+
+	r = newSampleReservoir(reservoirSize)
+	for i := 0; i < 100000; i++ {
+		r.Add(float64(i))
+	}
+	assert.Equal(t, len(r.samples), reservoirSize)
+	assert.Equal(t, r.Count(), 100000)
+	assert.Equal(t, r.Mean(), 49999.5)
+	assert.Equal(t, r.Max(), 99999.)
+	// the sample is uniform over all values added
+	assert.Assert(t, math.Abs(r.Percentile(50)-50000) < 5000, "got %f", r.Percentile(50))
+	assert.Assert(t, math.Abs(r.Percentile(95)-95000) < 5000, "got %f", r.Percentile(95))
+}
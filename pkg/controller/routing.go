@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+// dropPublishingScope is the scope of a route whose events are not published
+const dropPublishingScope = "none"
+
+// PublishingRoute sends events of EventType to Scope. EventType ending with "*" matches
+// event types with the prefix. If RatePerMinute is greater than 0, events over the rate
+// are not published. A burst of up to RatePerMinute events is allowed
+type PublishingRoute struct {
+	EventType     string
+	Scope         string
+	RatePerMinute float64
+}
+
+func (r PublishingRoute) matches(eventType datatype.EventType) bool {
+	if prefix, found := strings.CutSuffix(r.EventType, "*"); found {
+		return strings.HasPrefix(string(eventType), prefix)
+	}
+	return string(eventType) == r.EventType
+}
+
+// PublishingRoutes is a list of routes. As a flag, it is a comma separated list of
+// <event type>=<scope>[:<rate per minute>], e.g. sys.plugin.perf.*=node,sys.plugin.perf.summary=beehive:6
+type PublishingRoutes []PublishingRoute
+
+func (routes *PublishingRoutes) String() string {
+	if routes == nil {
+		return ""
+	}
+	var s []string
+	for _, r := range *routes {
+		route := r.EventType + "=" + r.Scope
+		if r.RatePerMinute > 0 {
+			route += ":" + strconv.FormatFloat(r.RatePerMinute, 'g', -1, 64)
+		}
+		s = append(s, route)
+	}
+	return strings.Join(s, ",")
+}
+
+func (routes *PublishingRoutes) Set(s string) error {
+	var parsed PublishingRoutes
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		eventType, target, found := strings.Cut(route, "=")
+		if !found || eventType == "" || target == "" {
+			return fmt.Errorf("route must be <event type>=<scope>[:<rate per minute>]: %q", route)
+		}
+		r := PublishingRoute{EventType: eventType, Scope: target}
+		if scope, rate, found := strings.Cut(target, ":"); found {
+			perMinute, err := strconv.ParseFloat(rate, 64)
+			if err != nil || perMinute < 0 {
+				return fmt.Errorf("invalid rate of route %q", route)
+			}
+			r.Scope, r.RatePerMinute = scope, perMinute
+		}
+		parsed = append(parsed, r)
+	}
+	*routes = parsed
+	return nil
+}
+
+// tokenBucket allows rate events per second with a burst of capacity
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens -= 1
+	return true
+}
+
+// PublishingRouter picks the publishing scope of events. The first route matching
+// the event type is taken. Events matching no route are published with the default scope
+type PublishingRouter struct {
+	DefaultScope string
+	Routes       PublishingRoutes
+
+	mu      sync.Mutex
+	buckets []*tokenBucket
+}
+
+func NewPublishingRouter(c ControllerConfig) *PublishingRouter {
+	buckets := make([]*tokenBucket, len(c.PublishingRoutes))
+	for i, r := range c.PublishingRoutes {
+		if r.RatePerMinute > 0 {
+			capacity := math.Max(1, r.RatePerMinute)
+			buckets[i] = &tokenBucket{rate: r.RatePerMinute / 60., capacity: capacity, tokens: capacity}
+		}
+	}
+	return &PublishingRouter{
+		DefaultScope: c.MetricsPublishingScope,
+		Routes:       c.PublishingRoutes,
+		buckets:      buckets,
+	}
+}
+
+// match returns the first route matching the event type or -1 if none matches
+func (r *PublishingRouter) match(eventType datatype.EventType) int {
+	for i, route := range r.Routes {
+		if route.matches(eventType) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Route returns the scope to publish the event type with. It returns false if
+// the event should not be published
+func (r *PublishingRouter) Route(eventType datatype.EventType, now time.Time) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.match(eventType)
+	if i < 0 {
+		return r.DefaultScope, true
+	}
+	route := r.Routes[i]
+	if route.Scope == dropPublishingScope {
+		return "", false
+	}
+	if b := r.buckets[i]; b != nil && !b.allow(now) {
+		publishRateLimited.WithLabelValues(route.EventType).Inc()
+		return "", false
+	}
+	return route.Scope, true
+}
+
+// Lookup returns the scope of the event type like Route without taking the rate limit
+// of the route. Samples to aggregate are looked up, as their aggregate takes the rate limit
+func (r *PublishingRouter) Lookup(eventType datatype.EventType) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.match(eventType)
+	if i < 0 {
+		return r.DefaultScope, true
+	}
+	if r.Routes[i].Scope == dropPublishingScope {
+		return "", false
+	}
+	return r.Routes[i].Scope, true
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestPublishingRoutesFlag(t *testing.T) {
+	var routes PublishingRoutes
+	assert.NilError(t, routes.Set("sys.plugin.perf.summary=beehive:6, sys.plugin.perf.*=node,sys.plugin.debug=none"))
+	assert.DeepEqual(t, routes, PublishingRoutes{
+		{EventType: "sys.plugin.perf.summary", Scope: "beehive", RatePerMinute: 6},
+		{EventType: "sys.plugin.perf.*", Scope: "node"},
+		{EventType: "sys.plugin.debug", Scope: "none"},
+	})
+	assert.Equal(t, routes.String(), "sys.plugin.perf.summary=beehive:6,sys.plugin.perf.*=node,sys.plugin.debug=none")
+
+	assert.ErrorContains(t, routes.Set("sys.plugin.perf.cpu"), "route must be")
+	assert.ErrorContains(t, routes.Set("sys.plugin.perf.cpu=beehive:fast"), "invalid rate")
+}
+
+func TestPublishingRouter(t *testing.T) {
+	var routes PublishingRoutes
+	assert.NilError(t, routes.Set("sys.plugin.perf.summary=beehive:2,sys.plugin.perf.*=node,sys.plugin.debug=none"))
+	r := NewPublishingRouter(ControllerConfig{MetricsPublishingScope: "all", PublishingRoutes: routes})
+	now := time.Now()
+
+	scope, ok := r.Route(datatype.EventPluginPerfCPU, now)
+	assert.Assert(t, ok)
+	assert.Equal(t, scope, "node")
+	scope, ok = r.Route(EventPluginBudgetWarn, now)
+	assert.Assert(t, ok)
+	assert.Equal(t, scope, "all")
+	_, ok = r.Route("sys.plugin.debug", now)
+	assert.Assert(t, !ok)
+
+	// 2 messages per minute with a burst of 2
+	for i := 0; i < 2; i++ {
+		scope, ok = r.Route(EventPluginPerfSummary, now)
+		assert.Assert(t, ok)
+		assert.Equal(t, scope, "beehive")
+	}
+	_, ok = r.Route(EventPluginPerfSummary, now)
+	assert.Assert(t, !ok)
+	_, ok = r.Route(EventPluginPerfSummary, now.Add(30*time.Second))
+	assert.Assert(t, ok)
+
+	// lookups do not take the rate limit
+	for i := 0; i < 5; i++ {
+		scope, ok = r.Lookup(EventPluginPerfSummary)
+		assert.Assert(t, ok)
+		assert.Equal(t, scope, "beehive")
+	}
+	_, ok = r.Lookup("sys.plugin.debug")
+	assert.Assert(t, !ok)
+	scope, ok = r.Lookup(EventPluginBudgetWarn)
+	assert.Assert(t, ok)
+	assert.Equal(t, scope, "all")
+}
@@ -0,0 +1,414 @@
+package controller
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+const (
+	// a point is stored as a little-endian int64 of unix milliseconds followed by a float64
+	seriesPointSize    = 16
+	seriesDayLayout    = "20060102"
+	seriesRawExt       = ".raw"
+	seriesDownsampled  = ".ds"
+	seriesCompactEvery = time.Hour
+	// seriesQueueSize is the number of samples that wait to be written
+	seriesQueueSize = 1000
+)
+
+// seriesSample is a sample waiting to be written to the series
+type seriesSample struct {
+	series string
+	t      time.Time
+	v      float64
+}
+
+// SeriesPoint is a sample of a series
+type SeriesPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// SeriesStore keeps every numeric event as a sample of the series named by the event type.
+// Samples are appended to a file per series per UTC day under Dir. Days older than
+// DownsampleAfter are rewritten as averages over DownsampleStep and days older than
+// Retention are removed. Observed samples are queued and written by Run, so the event
+// loop does not wait for the disk
+type SeriesStore struct {
+	Dir             string
+	Retention       time.Duration
+	DownsampleAfter time.Duration
+	DownsampleStep  time.Duration
+
+	mu      sync.Mutex
+	files   map[string]*os.File
+	samples chan seriesSample
+	quit    chan struct{}
+	stopped chan struct{}
+	log     *slog.Logger
+}
+
+func NewSeriesStore(c ControllerConfig) (*SeriesStore, error) {
+	if err := os.MkdirAll(c.StoreDir, 0755); err != nil {
+		return nil, err
+	}
+	return &SeriesStore{
+		Dir:             c.StoreDir,
+		Retention:       time.Duration(c.StoreRetentionDays) * 24 * time.Hour,
+		DownsampleAfter: time.Duration(c.StoreDownsampleAfterDays) * 24 * time.Hour,
+		DownsampleStep:  time.Duration(c.StoreDownsampleStep) * time.Second,
+		files:           map[string]*os.File{},
+		samples:         make(chan seriesSample, seriesQueueSize),
+		quit:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+		log:             componentLogger("store"),
+	}, nil
+}
+
+// seriesDir returns the directory of the series. Names that escape as a path
+// element other than a directory of the store are rejected
+func (s *SeriesStore) seriesDir(series string) (string, error) {
+	switch series {
+	case "", ".", "..":
+		return "", fmt.Errorf("invalid series name %q", series)
+	}
+	return path.Join(s.Dir, url.PathEscape(series)), nil
+}
+
+// Observe queues the event to be stored if its value is a number. The sample is
+// dropped if the queue is full
+func (s *SeriesStore) Observe(e datatype.Event) {
+	v, ok := eventValue(e)
+	if !ok {
+		return
+	}
+	select {
+	case s.samples <- seriesSample{series: string(e.Type), t: time.Unix(0, e.Timestamp), v: v}:
+	default:
+		storeSamplesDropped.Inc()
+	}
+}
+
+func (s *SeriesStore) write(sample seriesSample) {
+	if err := s.Append(sample.series, sample.t, sample.v); err != nil {
+		s.log.Error("failed to store sample", "series", sample.series, "error", err)
+	}
+}
+
+// drain writes the queued samples
+func (s *SeriesStore) drain() {
+	for {
+		select {
+		case sample := <-s.samples:
+			s.write(sample)
+		default:
+			return
+		}
+	}
+}
+
+// Append adds the sample to the series. A sample of a day that is already downsampled
+// is refused, as the raw samples of the day are gone
+func (s *SeriesStore) Append(series string, t time.Time, v float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return err
+	}
+	day := t.UTC().Format(seriesDayLayout)
+	filePath := path.Join(dir, day+seriesRawExt)
+	f, found := s.files[series]
+	if !found || f.Name() != filePath {
+		if found {
+			f.Close()
+			delete(s.files, series)
+		}
+		if _, err := os.Stat(path.Join(dir, day+seriesDownsampled)); err == nil {
+			return fmt.Errorf("day %s of series %s is already downsampled", day, series)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if f, err = os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			delete(s.files, series)
+			return err
+		}
+		s.files[series] = f
+	}
+	_, err = f.Write(encodeSeriesPoint(t, v))
+	return err
+}
+
+func encodeSeriesPoint(t time.Time, v float64) []byte {
+	buf := make([]byte, seriesPointSize)
+	binary.LittleEndian.PutUint64(buf, uint64(t.UnixMilli()))
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(v))
+	return buf
+}
+
+// readSeriesFile reads the points of a file. A partially written point at the end is ignored
+func readSeriesFile(filePath string) ([]SeriesPoint, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]SeriesPoint, 0, len(buf)/seriesPointSize)
+	for i := 0; i+seriesPointSize <= len(buf); i += seriesPointSize {
+		points = append(points, SeriesPoint{
+			Timestamp: time.UnixMilli(int64(binary.LittleEndian.Uint64(buf[i:]))).UTC(),
+			Value:     math.Float64frombits(binary.LittleEndian.Uint64(buf[i+8:])),
+		})
+	}
+	return points, nil
+}
+
+// seriesDayFile is a stored day of a series
+type seriesDayFile struct {
+	Path        string
+	Day         time.Time
+	Downsampled bool
+}
+
+func (s *SeriesStore) dayFiles(series string) ([]seriesDayFile, error) {
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []seriesDayFile
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if ext != seriesRawExt && ext != seriesDownsampled {
+			continue
+		}
+		day, err := time.Parse(seriesDayLayout, strings.TrimSuffix(entry.Name(), ext))
+		if err != nil {
+			continue
+		}
+		files = append(files, seriesDayFile{
+			Path:        path.Join(dir, entry.Name()),
+			Day:         day,
+			Downsampled: ext == seriesDownsampled,
+		})
+	}
+	return files, nil
+}
+
+// Series returns names of the stored series
+func (s *SeriesStore) Series() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if name, err := url.PathUnescape(entry.Name()); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Query returns the points of the series between start and end, oldest first.
+// If step is greater than 0, the points are averaged over each step
+func (s *SeriesStore) Query(series string, start time.Time, end time.Time, step time.Duration) ([]SeriesPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := s.dayFiles(series)
+	if err != nil {
+		return nil, err
+	}
+	points := []SeriesPoint{}
+	for _, f := range files {
+		if f.Day.Add(24*time.Hour).Before(start) || f.Day.After(end) {
+			continue
+		}
+		filePoints, err := readSeriesFile(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range filePoints {
+			if !p.Timestamp.Before(start) && !p.Timestamp.After(end) {
+				points = append(points, p)
+			}
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	if step > 0 {
+		points = downsample(points, step)
+	}
+	return points, nil
+}
+
+// downsample averages sorted points over each step. The point of a step
+// is timestamped at the start of the step
+func downsample(points []SeriesPoint, step time.Duration) []SeriesPoint {
+	result := []SeriesPoint{}
+	var sum float64
+	var count int
+	var bucket time.Time
+	for _, p := range points {
+		b := p.Timestamp.Truncate(step)
+		if count > 0 && !b.Equal(bucket) {
+			result = append(result, SeriesPoint{Timestamp: bucket, Value: sum / float64(count)})
+			sum, count = 0, 0
+		}
+		bucket = b
+		sum += p.Value
+		count += 1
+	}
+	if count > 0 {
+		result = append(result, SeriesPoint{Timestamp: bucket, Value: sum / float64(count)})
+	}
+	return result
+}
+
+// Compact removes days older than the retention and downsamples days older than
+// DownsampleAfter. A day is processed once the whole day is older than the threshold
+func (s *SeriesStore) Compact(now time.Time) error {
+	series, err := s.Series()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range series {
+		files, err := s.dayFiles(name)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			dayEnd := f.Day.Add(24 * time.Hour)
+			switch {
+			case s.Retention > 0 && dayEnd.Before(now.Add(-s.Retention)):
+				if err := os.Remove(f.Path); err != nil {
+					return err
+				}
+			case !f.Downsampled && s.DownsampleAfter > 0 && s.DownsampleStep > 0 && dayEnd.Before(now.Add(-s.DownsampleAfter)):
+				if err := s.downsampleFile(name, f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SeriesStore) downsampleFile(series string, f seriesDayFile) error {
+	if open, found := s.files[series]; found && open.Name() == f.Path {
+		open.Close()
+		delete(s.files, series)
+	}
+	points, err := readSeriesFile(f.Path)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	dsPath := strings.TrimSuffix(f.Path, seriesRawExt) + seriesDownsampled
+	tmpPath := dsPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range downsample(points, s.DownsampleStep) {
+		if _, err := out.Write(encodeSeriesPoint(p.Timestamp, p.Value)); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, dsPath); err != nil {
+		return err
+	}
+	return os.Remove(f.Path)
+}
+
+// WriteCSV writes the points of the series as timestamp,series,value rows
+func WriteCSV(w io.Writer, series string, points []SeriesPoint) error {
+	if _, err := fmt.Fprintln(w, "timestamp,series,value"); err != nil {
+		return err
+	}
+	for _, p := range points {
+		if _, err := fmt.Fprintf(w, "%s,%s,%g\n", p.Timestamp.Format(time.RFC3339Nano), series, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSONLines writes a JSON object per point of the series
+func WriteJSONLines(w io.Writer, series string, points []SeriesPoint) error {
+	encoder := json.NewEncoder(w)
+	for _, p := range points {
+		if err := encoder.Encode(map[string]interface{}{
+			"timestamp": p.Timestamp,
+			"series":    series,
+			"value":     p.Value,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops Run and waits for the queued samples to be written
+func (s *SeriesStore) Stop() {
+	s.quit <- struct{}{}
+	<-s.stopped
+}
+
+// Run writes the queued samples and compacts the store every hour. When it stops,
+// the rest of the queue is written and the files are closed
+func (s *SeriesStore) Run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(seriesCompactEvery)
+	for {
+		select {
+		case sample := <-s.samples:
+			s.write(sample)
+		case t := <-ticker.C:
+			if err := s.Compact(t); err != nil {
+				s.log.Error("failed to compact", "error", err)
+			}
+		case <-s.quit:
+			ticker.Stop()
+			s.drain()
+			s.mu.Lock()
+			for series, f := range s.files {
+				f.Close()
+				delete(s.files, series)
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestSeriesStoreQuery(t *testing.T) {
+	s, err := NewSeriesStore(ControllerConfig{StoreDir: t.TempDir()})
+	assert.NilError(t, err)
+	start := time.Date(2023, 7, 1, 23, 59, 0, 0, time.UTC)
+	// samples cross the day boundary
+	for i := 0; i < 4; i++ {
+		assert.NilError(t, s.Append("sys.plugin.perf.cpu", start.Add(time.Duration(i)*30*time.Second), float64(i)))
+	}
+	s.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, start, 100))
+	s.Observe(datatype.NewEventBuilder(datatype.EventPluginPerfMem).AddValue("not a number").Build())
+	s.drain()
+
+	series, err := s.Series()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, series, []string{"sys.plugin.perf.cpu", "sys.plugin.perf.mem"})
+
+	points, err := s.Query("sys.plugin.perf.cpu", start, start.Add(time.Hour), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 4)
+	assert.Equal(t, points[3].Value, 3.)
+
+	points, err = s.Query("sys.plugin.perf.cpu", start.Add(time.Minute), start.Add(time.Hour), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 2)
+
+	points, err = s.Query("sys.plugin.perf.cpu", start, start.Add(time.Hour), time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0].Value, 0.5)
+	assert.Equal(t, points[1].Value, 2.5)
+
+	points, err = s.Query("no.such.series", start, start.Add(time.Hour), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 0)
+
+	// names that are not a directory of the store are rejected
+	for _, name := range []string{"", ".", ".."} {
+		assert.ErrorContains(t, s.Append(name, start, 1), "invalid series name")
+		_, err = s.Query(name, start, start.Add(time.Hour), 0)
+		assert.ErrorContains(t, err, "invalid series name")
+	}
+}
+
+func TestSeriesStoreRun(t *testing.T) {
+	s, err := NewSeriesStore(ControllerConfig{StoreDir: t.TempDir()})
+	assert.NilError(t, err)
+	go s.Run()
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		s.Observe(newTestPerfEvent(datatype.EventPluginPerfCPU, start.Add(time.Duration(i)*time.Second), float64(i)))
+	}
+	// the queued samples are written before the store stops
+	s.Stop()
+	points, err := s.Query("sys.plugin.perf.cpu", start, start.Add(time.Hour), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 10)
+	assert.Equal(t, len(s.files), 0)
+}
+
+func TestSeriesStoreCompact(t *testing.T) {
+	s, err := NewSeriesStore(ControllerConfig{
+		StoreDir:                 t.TempDir(),
+		StoreRetentionDays:       7,
+		StoreDownsampleAfterDays: 1,
+		StoreDownsampleStep:      60,
+	})
+	assert.NilError(t, err)
+	now := time.Date(2023, 7, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-10 * 24 * time.Hour)
+	twoDaysAgo := time.Date(2023, 7, 8, 10, 0, 0, 0, time.UTC)
+	assert.NilError(t, s.Append("sys.plugin.perf.cpu", old, 1))
+	for i := 0; i < 6; i++ {
+		assert.NilError(t, s.Append("sys.plugin.perf.cpu", twoDaysAgo.Add(time.Duration(i)*10*time.Second), float64(i)))
+	}
+	assert.NilError(t, s.Append("sys.plugin.perf.cpu", now, 9))
+	assert.NilError(t, s.Compact(now))
+
+	files, err := s.dayFiles("sys.plugin.perf.cpu")
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 2)
+	points, err := s.Query("sys.plugin.perf.cpu", old, now, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0].Value, 2.5)
+	assert.Equal(t, points[1].Value, 9.)
+
+	// a late sample of a downsampled day does not replace the downsampled day
+	assert.ErrorContains(t, s.Append("sys.plugin.perf.cpu", twoDaysAgo, 100), "already downsampled")
+	assert.NilError(t, s.Compact(now))
+	points, err = s.Query("sys.plugin.perf.cpu", twoDaysAgo, twoDaysAgo.Add(time.Hour), 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points, []SeriesPoint{{Timestamp: twoDaysAgo, Value: 2.5}})
+
+	// samples keep being appended after compaction
+	assert.NilError(t, s.Append("sys.plugin.perf.cpu", now.Add(time.Second), 10))
+	points, err = s.Query("sys.plugin.perf.cpu", now, now.Add(time.Minute), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 2)
+}
+
+func TestSeriesExport(t *testing.T) {
+	points := []SeriesPoint{{Timestamp: time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), Value: 1.5}}
+	var buf bytes.Buffer
+	assert.NilError(t, WriteCSV(&buf, "sys.plugin.perf.cpu", points))
+	assert.Equal(t, buf.String(), "timestamp,series,value\n2023-07-01T00:00:00Z,sys.plugin.perf.cpu,1.5\n")
+	buf.Reset()
+	assert.NilError(t, WriteJSONLines(&buf, "sys.plugin.perf.cpu", points))
+	assert.Equal(t, buf.String(), `{"series":"sys.plugin.perf.cpu","timestamp":"2023-07-01T00:00:00Z","value":1.5}`+"\n")
+}
+
+func TestQueryRangeAPI(t *testing.T) {
+	store, err := NewSeriesStore(ControllerConfig{StoreDir: t.TempDir()})
+	assert.NilError(t, err)
+	api := NewAPIServer(ControllerConfig{})
+	api.store = store
+	assert.NilError(t, api.store.Append("sys.plugin.perf.cpu", time.Now().Add(-time.Minute), 42))
+
+	rec := httptest.NewRecorder()
+	api.handlerQueryRange(rec, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?series=sys.plugin.perf.cpu&start=10m", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, strings.Contains(rec.Body.String(), "42"))
+
+	rec = httptest.NewRecorder()
+	api.handlerQueryRange(rec, httptest.NewRequest(http.MethodGet, "/api/v1/query_range", nil))
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+
+	rec = httptest.NewRecorder()
+	api.handlerExport(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export?series=sys.plugin.perf.cpu&format=jsonl", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, strings.Count(rec.Body.String(), "\n"), 1)
+
+	_, err = os.Stat(path.Join(api.store.Dir, "sys.plugin.perf.cpu"))
+	assert.NilError(t, err)
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+const (
+	EventPluginPerfSummary datatype.EventType = "sys.plugin.perf.summary"
+)
+
+// RunSummaryReport is the performance profile of a plugin run
+type RunSummaryReport struct {
+	StartedAt            time.Time `json:"started_at"`
+	FinishedAt           time.Time `json:"finished_at"`
+	WallTimeSeconds      float64   `json:"wall_time_seconds"`
+	CPUSecondsTotal      float64   `json:"cpu_seconds_total"`
+	CPUPercentAvg        float64   `json:"cpu_percent_avg"`
+	CPUPercentP95        float64   `json:"cpu_percent_p95"`
+	MemoryWorkingSetPeak float64   `json:"memory_workingset_peak_bytes"`
+	MemoryWorkingSetAvg  float64   `json:"memory_workingset_avg_bytes"`
+	GPULoadAvg           float64   `json:"gpu_load_avg"`
+	GPULoadPeak          float64   `json:"gpu_load_peak"`
+	EnergyJoules         float64   `json:"energy_joules"`
+	CPUSamples           int       `json:"cpu_samples"`
+	MemorySamples        int       `json:"memory_samples"`
+	GPUSamples           int       `json:"gpu_samples"`
+}
+
+// RunSummary accumulates performance samples of the plugin over its run
+type RunSummary struct {
+	startedAt    time.Time
+	cpuPercs     *sampleReservoir
+	cpuSeconds   float64
+	memoryPeak   float64
+	memorySum    float64
+	memoryCount  int
+	gpuPeak      float64
+	gpuSum       float64
+	gpuCount     int
+	energyJoules float64
+}
+
+func NewRunSummary(startedAt time.Time) *RunSummary {
+	return &RunSummary{
+		startedAt: startedAt,
+		cpuPercs:  newSampleReservoir(reservoirSize),
+	}
+}
+
+// eventValue returns the numeric value of a performance event
+func eventValue(e datatype.Event) (float64, bool) {
+	switch v := e.Meta["value"].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// Observe takes CPU, memory, GPU and energy performance events into the summary
+func (s *RunSummary) Observe(e datatype.Event) {
+	v, ok := eventValue(e)
+	if !ok {
+		return
+	}
+	switch e.Type {
+	case datatype.EventPluginPerfCPU:
+		// CPU time is taken from the cumulative CPU time of the plugin's cgroup,
+		// which counts from the start of the plugin's container
+		if total, ok := e.Meta[cpuSecondsTotalEntry].(float64); ok {
+			s.cpuSeconds = total
+		}
+		s.cpuPercs.Add(v)
+	case datatype.EventPluginPerfMem:
+		s.memoryPeak = math.Max(s.memoryPeak, v)
+		s.memorySum += v
+		s.memoryCount += 1
+	case datatype.EventPluginPerfGPU:
+		s.gpuPeak = math.Max(s.gpuPeak, v)
+		s.gpuSum += v
+		s.gpuCount += 1
+	case EventPluginPerfEnergy:
+		// energy is reported cumulatively
+		s.energyJoules = v
+	}
+}
+
+// Report returns the summary of the run finished at the given time
+func (s *RunSummary) Report(finishedAt time.Time) RunSummaryReport {
+	r := RunSummaryReport{
+		StartedAt:            s.startedAt,
+		FinishedAt:           finishedAt,
+		WallTimeSeconds:      finishedAt.Sub(s.startedAt).Seconds(),
+		CPUSecondsTotal:      s.cpuSeconds,
+		MemoryWorkingSetPeak: s.memoryPeak,
+		GPULoadPeak:          s.gpuPeak,
+		EnergyJoules:         s.energyJoules,
+		CPUPercentAvg:        s.cpuPercs.Mean(),
+		CPUPercentP95:        s.cpuPercs.Percentile(95),
+		CPUSamples:           s.cpuPercs.Count(),
+		MemorySamples:        s.memoryCount,
+		GPUSamples:           s.gpuCount,
+	}
+	if s.memoryCount > 0 {
+		r.MemoryWorkingSetAvg = s.memorySum / float64(s.memoryCount)
+	}
+	if s.gpuCount > 0 {
+		r.GPULoadAvg = s.gpuSum / float64(s.gpuCount)
+	}
+	return r
+}
+
+// WriteFile writes the report as JSON
+func (r RunSummaryReport) WriteFile(filePath string) error {
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, blob, 0644)
+}
+
+// ToEvent returns the report as an event whose value is the JSON encoded report
+func (r RunSummaryReport) ToEvent() (datatype.Event, error) {
+	blob, err := json.Marshal(r)
+	if err != nil {
+		return datatype.Event{}, err
+	}
+	return datatype.NewEventBuilder(EventPluginPerfSummary).
+		AddValue(string(blob)).
+		Build(), nil
+}
+
+// percentile returns the p-th percentile of sorted values using linear interpolation
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100. * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
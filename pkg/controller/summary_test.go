@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func newTestPerfEvent(eventType datatype.EventType, t time.Time, v float64) datatype.Event {
+	e := datatype.NewEventBuilder(eventType).AddValue(v).Build()
+	e.Timestamp = t.UnixNano()
+	return e
+}
+
+func TestRunSummary(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	s := NewRunSummary(start)
+	// the plugin uses 2 cores for 10 seconds then 1 core for 10 seconds
+	cpu := newTestPerfEvent(datatype.EventPluginPerfCPU, start.Add(10*time.Second), 200)
+	cpu.Meta[cpuSecondsTotalEntry] = 20.
+	s.Observe(cpu)
+	cpu = newTestPerfEvent(datatype.EventPluginPerfCPU, start.Add(20*time.Second), 100)
+	cpu.Meta[cpuSecondsTotalEntry] = 30.
+	s.Observe(cpu)
+	s.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, start.Add(10*time.Second), 1000))
+	s.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, start.Add(20*time.Second), 3000))
+	s.Observe(newTestPerfEvent(datatype.EventPluginPerfGPU, start.Add(10*time.Second), 40))
+	s.Observe(datatype.NewEventBuilder(EventPluginBudgetWarn).AddValue("not a sample").Build())
+
+	r := s.Report(start.Add(30 * time.Second))
+	assert.Equal(t, r.WallTimeSeconds, 30.)
+	assert.Equal(t, r.CPUSecondsTotal, 30.)
+	assert.Equal(t, r.CPUPercentAvg, 150.)
+	assert.Equal(t, r.CPUPercentP95, 195.)
+	assert.Equal(t, r.MemoryWorkingSetPeak, 3000.)
+	assert.Equal(t, r.MemoryWorkingSetAvg, 2000.)
+	assert.Equal(t, r.GPULoadAvg, 40.)
+	assert.Equal(t, r.GPUSamples, 1)
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, percentile(sorted, 0), 1.)
+	assert.Equal(t, percentile(sorted, 50), 5.5)
+	assert.Equal(t, percentile(sorted, 100), 10.)
+	assert.Equal(t, percentile(nil, 95), 0.)
+}
+
+func TestRunSummarySamplesCPU(t *testing.T) {
+	// the summary and the windows consume samples of the CPU collector without CPU performance logging
+	for _, c := range []ControllerConfig{
+		{EnableRunSummary: true},
+		{EnablePerformanceWindows: true},
+		{PublishWindowedStats: true},
+	} {
+		assert.Assert(t, needsCPUCollector(c))
+		assert.Assert(t, needsCPUSamples(c))
+	}
+	assert.Assert(t, !needsCPUCollector(ControllerConfig{}))
+}
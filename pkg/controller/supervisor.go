@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+)
+
+const (
+	EventPluginExit datatype.EventType = "sys.plugin.exit"
+)
+
+// forwardedSignals are the signals that the supervisor passes on to the plugin
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// helperProcesses are the PIDs of commands that the controller runs itself, such as
+// nvidia-smi. Their commands wait for them, so they are not reaped as orphans
+var helperProcesses = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: map[int]bool{}}
+
+// helperOutput runs the command and returns its stdout like Output of exec.Cmd.
+// The process is registered as a helper while it runs, so the supervisor does not
+// reap it before the command waits for it
+func helperOutput(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	}
+	// the reaper holds the lock while it reaps, so the helper is registered before
+	// it can be seen as an exited child
+	helperProcesses.Lock()
+	err := cmd.Start()
+	if err == nil {
+		helperProcesses.pids[cmd.Process.Pid] = true
+	}
+	helperProcesses.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Wait()
+	helperProcesses.Lock()
+	delete(helperProcesses.pids, cmd.Process.Pid)
+	helperProcesses.Unlock()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Stderr == nil {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// Supervisor runs the plugin as a child process when the controller is the entrypoint
+// of the container. Output of the plugin is copied line by line to Stdout and Stderr
+// and handed to Lines, signals to the controller are forwarded to the plugin and the
+// started marker is created once the plugin is started. The plugin's exit does not
+// wait for its output to close, as background processes of the plugin may keep it
+// open. The rest of the output is read for up to DrainTimeout after the exit.
+// As PID 1 of the container, the supervisor also reaps orphaned processes that are
+// reparented to it, so they do not pile up as zombies. The plugin and helper commands
+// run by helperOutput are left to Wait
+type Supervisor struct {
+	Command      []string
+	StartedPath  string
+	Stdout       io.Writer
+	Stderr       io.Writer
+	Lines        func(stream string, line string)
+	DrainTimeout time.Duration
+	ReapOrphans  bool
+	ProcDir      string
+	cmd          *exec.Cmd
+	signals      chan os.Signal
+	done         chan struct{}
+	exitCode     int
+	log          *slog.Logger
+}
+
+func NewSupervisor(c ControllerConfig) *Supervisor {
+	procDir := c.ProcfsRoot
+	if procDir == "" {
+		procDir = "/proc"
+	}
+	return &Supervisor{
+		Command:      c.PluginCommand,
+		StartedPath:  PluginProcessStartedPath,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		DrainTimeout: 2 * time.Second,
+		ReapOrphans:  os.Getpid() == 1,
+		ProcDir:      procDir,
+		done:         make(chan struct{}),
+		log:          componentLogger("supervisor"),
+	}
+}
+
+// copyLines copies lines of the plugin's output to w and hands them to Lines.
+// A line is written as a whole, so lines of the plugin and the controller do not interleave
+func (s *Supervisor) copyLines(r io.Reader, w io.Writer, stream string) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			io.WriteString(w, line)
+			if s.Lines != nil {
+				s.Lines(stream, strings.TrimRight(line, "\r\n"))
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				s.log.Error("failed to read output of the plugin", "stream", stream, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Start starts the plugin. The plugin inherits the controller's stdin and environment
+func (s *Supervisor) Start() error {
+	if len(s.Command) == 0 {
+		return fmt.Errorf("no plugin command is given")
+	}
+	s.cmd = exec.Command(s.Command[0], s.Command[1:]...)
+	s.cmd.Stdin = os.Stdin
+	// the plugin writes to pipes of files, so Wait returns when the plugin exits
+	// and does not wait for processes that inherited the pipes
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutReader.Close()
+		stdoutWriter.Close()
+		return err
+	}
+	s.cmd.Stdout = stdoutWriter
+	s.cmd.Stderr = stderrWriter
+	// signals are subscribed before the start, so none is lost in between
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, forwardedSignals...)
+	var exited chan os.Signal
+	if s.ReapOrphans {
+		exited = make(chan os.Signal, 1)
+		signal.Notify(exited, syscall.SIGCHLD)
+	}
+	err = s.cmd.Start()
+	// the writers belong to the plugin now
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		signal.Stop(s.signals)
+		if exited != nil {
+			signal.Stop(exited)
+		}
+		stdoutReader.Close()
+		stderrReader.Close()
+		return err
+	}
+	s.log.Info("plugin started", "command", s.Command, "pid", s.cmd.Process.Pid)
+	if s.StartedPath != "" {
+		if err := os.MkdirAll(path.Dir(s.StartedPath), 0755); err != nil {
+			s.log.Error("failed to create started marker", "path", s.StartedPath, "error", err)
+		} else if err := os.WriteFile(s.StartedPath, []byte(time.Now().UTC().Format(time.RFC3339Nano)), 0644); err != nil {
+			s.log.Error("failed to create started marker", "path", s.StartedPath, "error", err)
+		}
+	}
+	output := make(chan struct{}, 2)
+	go func() {
+		s.copyLines(stdoutReader, s.Stdout, LogStreamStdout)
+		output <- struct{}{}
+	}()
+	go func() {
+		s.copyLines(stderrReader, s.Stderr, LogStreamStderr)
+		output <- struct{}{}
+	}()
+	go s.forwardSignals()
+	if exited != nil {
+		go s.reapOrphans(exited)
+	}
+	go func() {
+		err := s.cmd.Wait()
+		s.exitCode = exitCode(s.cmd.ProcessState, err)
+		signal.Stop(s.signals)
+		s.log.Info("plugin exited", "pid", s.cmd.Process.Pid, "exit_code", s.exitCode)
+		s.drain(output, stdoutReader, stderrReader)
+		close(s.done)
+	}()
+	return nil
+}
+
+// drain waits for the rest of the output of the plugin up to DrainTimeout. The output
+// is closed afterwards, which leaves background processes of the plugin a broken pipe
+func (s *Supervisor) drain(output <-chan struct{}, readers ...*os.File) {
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	timeout := time.NewTimer(s.DrainTimeout)
+	defer timeout.Stop()
+	for i := 0; i < len(readers); i++ {
+		select {
+		case <-output:
+		case <-timeout.C:
+			s.log.Warn("output of the plugin is still open after its exit. background processes of the plugin may hold it", "timeout", s.DrainTimeout)
+			return
+		}
+	}
+}
+
+// forwardSignals passes signals to the plugin until the plugin exits
+func (s *Supervisor) forwardSignals() {
+	for {
+		select {
+		case sig := <-s.signals:
+			s.log.Info("forwarding signal to the plugin", "signal", sig)
+			if err := s.cmd.Process.Signal(sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				s.log.Error("failed to forward signal", "signal", sig, "error", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reapOrphans waits for exited children other than the plugin and helpers whenever
+// a child exits, until the plugin exits
+func (s *Supervisor) reapOrphans(exited chan os.Signal) {
+	defer signal.Stop(exited)
+	for {
+		select {
+		case <-exited:
+			// signals of children exiting at once may be delivered as one
+			pids, err := listZombieChildren(s.ProcDir, int32(os.Getpid()))
+			if err != nil {
+				s.log.Error("failed to list exited children", "error", err)
+				continue
+			}
+			helperProcesses.Lock()
+			for _, pid := range pids {
+				if int(pid) == s.cmd.Process.Pid || helperProcesses.pids[int(pid)] {
+					continue
+				}
+				var status syscall.WaitStatus
+				if _, err := syscall.Wait4(int(pid), &status, syscall.WNOHANG, nil); err != nil {
+					s.log.Debug("failed to reap orphan", "orphan_pid", pid, "error", err)
+					continue
+				}
+				s.log.Debug("orphan reaped", "orphan_pid", pid, "exit_code", status.ExitStatus())
+			}
+			helperProcesses.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// exitCode returns the exit status of the process. A process killed by a signal
+// exits with 128 plus the signal number as shells report it
+func exitCode(state *os.ProcessState, err error) int {
+	if state == nil {
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// Pid returns the process ID of the plugin
+func (s *Supervisor) Pid() int {
+	return s.cmd.Process.Pid
+}
+
+// Done is closed when the plugin exits
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// ExitCode returns the exit status of the plugin once Done is closed
+func (s *Supervisor) ExitCode() int {
+	return s.exitCode
+}
+
+// ExitEvent returns the event that reports the exit status of the plugin
+func (s *Supervisor) ExitEvent() datatype.Event {
+	return datatype.NewEventBuilder(EventPluginExit).
+		AddValue(s.exitCode).
+		AddEntry("command", strings.Join(s.Command, " ")).
+		Build()
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// prSetChildSubreaper makes orphaned descendants reparent to the caller as to PID 1
+const prSetChildSubreaper = 36
+
+func TestSupervisorReapOrphans(t *testing.T) {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	assert.Assert(t, errno == 0, errno)
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 0, 0)
+
+	// the subshell exits right away, so the sleep is reparented to the test
+	s, stdout, _ := newTestSupervisor(t, `(sleep 0.1 > /dev/null 2>&1 & echo $!); sleep 1`)
+	s.ReapOrphans = true
+	assert.NilError(t, s.Start())
+	for i := 0; stdout.String() == "" && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	orphan, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	assert.NilError(t, err)
+	orphanDir := path.Join("/proc", strconv.Itoa(orphan))
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(orphanDir); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_, err = os.Stat(orphanDir)
+	assert.Assert(t, os.IsNotExist(err), "orphan %d is not reaped", orphan)
+
+	// the plugin is waited by the supervisor, not by the reaper
+	waitForExit(t, s)
+	assert.Equal(t, s.ExitCode(), 0)
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// lockedBuffer is the output of a supervised plugin
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestSupervisor(t *testing.T, script string) (*Supervisor, *lockedBuffer, *lockedBuffer) {
+	s := NewSupervisor(ControllerConfig{PluginCommand: []string{"/bin/sh", "-c", script}})
+	s.StartedPath = path.Join(t.TempDir(), "app", "started")
+	stdout, stderr := &lockedBuffer{}, &lockedBuffer{}
+	s.Stdout, s.Stderr = stdout, stderr
+	return s, stdout, stderr
+}
+
+func waitForExit(t *testing.T, s *Supervisor) {
+	select {
+	case <-s.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin did not exit")
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	s, stdout, stderr := newTestSupervisor(t, `echo detected 3 cars; echo Traceback >&2; printf "no newline"; exit 3`)
+	var mu sync.Mutex
+	var lines []string
+	s.Lines = func(stream string, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, stream+": "+line)
+	}
+	assert.NilError(t, s.Start())
+	assert.Assert(t, s.Pid() > 0)
+	_, err := os.Stat(s.StartedPath)
+	assert.NilError(t, err)
+	waitForExit(t, s)
+
+	assert.Equal(t, s.ExitCode(), 3)
+	assert.Equal(t, stdout.String(), "detected 3 cars\nno newline")
+	assert.Equal(t, stderr.String(), "Traceback\n")
+	// stdout and stderr are read concurrently
+	sort.Strings(lines)
+	assert.DeepEqual(t, lines, []string{"stderr: Traceback", "stdout: detected 3 cars", "stdout: no newline"})
+
+	e := s.ExitEvent()
+	assert.Equal(t, e.Type, EventPluginExit)
+	assert.Equal(t, e.Meta["value"], 3)
+}
+
+func TestSupervisorForwardsSignals(t *testing.T) {
+	s, stdout, _ := newTestSupervisor(t, `trap 'echo terminating; exit 0' TERM; echo ready; while true; do sleep 0.1; done`)
+	assert.NilError(t, s.Start())
+	for i := 0; stdout.String() == "" && i < 100; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	// a signal to the controller goes to the plugin
+	s.signals <- syscall.SIGTERM
+	waitForExit(t, s)
+	assert.Equal(t, s.ExitCode(), 0)
+	assert.Equal(t, stdout.String(), "ready\nterminating\n")
+}
+
+func TestSupervisorBackgroundProcess(t *testing.T) {
+	// the background process keeps stdout and stderr of the plugin open
+	s, stdout, _ := newTestSupervisor(t, `sleep 100 & echo started $!; exit 4`)
+	s.DrainTimeout = 200 * time.Millisecond
+	assert.NilError(t, s.Start())
+	waitForExit(t, s)
+	assert.Equal(t, s.ExitCode(), 4)
+	assert.Assert(t, strings.HasPrefix(stdout.String(), "started "))
+	pid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(stdout.String(), "started ")))
+	assert.NilError(t, err)
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+func TestSupervisorReapsOrphansNotHelpers(t *testing.T) {
+	s, _, _ := newTestSupervisor(t, `sleep 5`)
+	s.ReapOrphans = true
+	assert.NilError(t, s.Start())
+	defer func() {
+		s.cmd.Process.Kill()
+		waitForExit(t, s)
+	}()
+	// every exit of a helper wakes up the reaper, which must leave the helper to its command
+	for i := 0; i < 20; i++ {
+		out, err := helperOutput(exec.Command("sh", "-c", "echo helper"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), "helper\n")
+	}
+	_, err := helperOutput(exec.Command("sh", "-c", "echo failed >&2; exit 2"))
+	var exitErr *exec.ExitError
+	assert.Assert(t, errors.As(err, &exitErr))
+	assert.Equal(t, exitErr.ExitCode(), 2)
+	assert.Equal(t, string(exitErr.Stderr), "failed\n")
+}
+
+func TestSupervisorExitCode(t *testing.T) {
+	s, _, _ := newTestSupervisor(t, `kill -KILL $$`)
+	assert.NilError(t, s.Start())
+	waitForExit(t, s)
+	assert.Equal(t, s.ExitCode(), 128+int(syscall.SIGKILL))
+
+	s = NewSupervisor(ControllerConfig{PluginCommand: []string{path.Join(t.TempDir(), "missing")}})
+	assert.Assert(t, s.Start() != nil)
+	assert.ErrorContains(t, NewSupervisor(ControllerConfig{}).Start(), "no plugin command")
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const otherThreadName = "other"
+
+type threadGroup struct {
+	Name       string
+	Threads    int
+	CPUSeconds float64
+}
+
+// threadKey identifies a thread. A thread ID may be reused after the thread exits
+type threadKey struct {
+	tid       string
+	startTime uint64
+}
+
+// ThreadPerformanceLogging exports CPU time of the threads in the plugin's process tree.
+// Threads with the same name are grouped into one series. The first MaxThreadNames groups
+// seen, busiest first, are exported by name and the rest are summed into the "other" group.
+// A group keeps CPU time of its threads that have exited and never moves to or from the
+// "other" group, so the CPU time of a group only goes up
+type ThreadPerformanceLogging struct {
+	ProcDir        string
+	PID            int32
+	MaxThreadNames int
+	log            *slog.Logger
+
+	mu      sync.Mutex
+	threads map[threadKey]float64
+	totals  map[string]float64
+	named   map[string]bool
+
+	promThreadCPUSeconds *prometheus.Desc
+	promThreads          *prometheus.Desc
+}
+
+func NewThreadPerformanceLogging(c ControllerConfig, pid int32) *ThreadPerformanceLogging {
+	procDir := c.ProcfsRoot
+	if procDir == "" {
+		procDir = "/proc"
+	}
+	return &ThreadPerformanceLogging{
+		ProcDir:        procDir,
+		PID:            pid,
+		MaxThreadNames: c.ThreadMetricsMaxNames,
+		log:            componentLogger("thread"),
+		threads:        map[threadKey]float64{},
+		totals:         map[string]float64{},
+		named:          map[string]bool{},
+
+		promThreadCPUSeconds: prometheus.NewDesc(
+			"plugin_thread_cpu_seconds_total",
+			"Cumulative cpu time consumed by plugin threads in seconds grouped by thread name",
+			[]string{"thread_name"},
+			nil,
+		),
+		promThreads: prometheus.NewDesc(
+			"plugin_threads",
+			"Number of plugin threads grouped by thread name",
+			[]string{"thread_name"},
+			nil,
+		),
+	}
+}
+
+func (t *ThreadPerformanceLogging) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.promThreadCPUSeconds
+	ch <- t.promThreads
+}
+
+func (t *ThreadPerformanceLogging) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	groups, err := t.ReadThreadCPUSeconds()
+	observeCollection("thread", start, err)
+	if err != nil {
+		t.log.Error("failed to read thread cpu seconds", "error", err)
+		return
+	}
+	for _, g := range groups {
+		ch <- prometheus.MustNewConstMetric(
+			t.promThreadCPUSeconds,
+			prometheus.CounterValue,
+			g.CPUSeconds,
+			g.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			t.promThreads,
+			prometheus.GaugeValue,
+			float64(g.Threads),
+			g.Name,
+		)
+	}
+}
+
+type threadSample struct {
+	name       string
+	cpuSeconds float64
+}
+
+// readThreads reads user and system time of all threads in the plugin's process tree
+func (t *ThreadPerformanceLogging) readThreads() (map[threadKey]threadSample, error) {
+	pids, err := listProcessTree(t.ProcDir, t.PID)
+	if err != nil {
+		return nil, err
+	}
+	threads := map[threadKey]threadSample{}
+	for _, pid := range pids {
+		taskDir := path.Join(t.ProcDir, fmt.Sprint(pid), "task")
+		tasks, err := os.ReadDir(taskDir)
+		if err != nil {
+			// the process may have exited after listing the tree
+			continue
+		}
+		for _, task := range tasks {
+			stat, err := readProcStat(path.Join(taskDir, task.Name(), "stat"))
+			if err != nil {
+				continue
+			}
+			name := stat.Name
+			if comm, err := os.ReadFile(path.Join(taskDir, task.Name(), "comm")); err == nil {
+				name = strings.TrimSpace(string(comm))
+			}
+			threads[threadKey{tid: task.Name(), startTime: stat.StartTime}] = threadSample{
+				name:       name,
+				cpuSeconds: stat.UTime + stat.STime,
+			}
+		}
+	}
+	return threads, nil
+}
+
+// group returns the exported group of the thread name. A name joins the named groups
+// while there is room and stays in its group afterwards
+func (t *ThreadPerformanceLogging) group(name string) string {
+	if t.named[name] {
+		return name
+	}
+	if name != otherThreadName && (t.MaxThreadNames <= 0 || len(t.named) < t.MaxThreadNames) {
+		t.named[name] = true
+		return name
+	}
+	return otherThreadName
+}
+
+// ReadThreadCPUSeconds returns CPU time of the plugin's threads since the collector
+// started, grouped by thread name, busiest first
+func (t *ThreadPerformanceLogging) ReadThreadCPUSeconds() ([]threadGroup, error) {
+	// the read is under the lock, so concurrent scrapes apply their samples in order
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	threads, err := t.readThreads()
+	if err != nil {
+		return nil, err
+	}
+	// CPU time since the last read is added to the group of the thread
+	deltas := map[string]float64{}
+	live := map[string]int{}
+	for key, sample := range threads {
+		// a thread is keyed by its start time, so its CPU time does not go back
+		delta := math.Max(sample.cpuSeconds-t.threads[key], 0)
+		deltas[sample.name] += delta
+		live[sample.name]++
+	}
+	// new names are assigned busiest first
+	names := make([]string, 0, len(deltas))
+	for name := range deltas {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if deltas[names[i]] == deltas[names[j]] {
+			return names[i] < names[j]
+		}
+		return deltas[names[i]] > deltas[names[j]]
+	})
+	liveByGroup := map[string]int{}
+	for _, name := range names {
+		g := t.group(name)
+		t.totals[g] += deltas[name]
+		liveByGroup[g] += live[name]
+	}
+	// exited threads are forgotten, their CPU time stays in the totals
+	t.threads = map[threadKey]float64{}
+	for key, sample := range threads {
+		t.threads[key] = sample.cpuSeconds
+	}
+	groups := make([]threadGroup, 0, len(t.totals))
+	for name, total := range t.totals {
+		groups = append(groups, threadGroup{Name: name, Threads: liveByGroup[name], CPUSeconds: total})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].CPUSeconds == groups[j].CPUSeconds {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].CPUSeconds > groups[j].CPUSeconds
+	})
+	return groups, nil
+}
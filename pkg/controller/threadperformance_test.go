@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/v3/assert"
+)
+
+// writeTestTask writes stat and comm files of a thread under the proc directory.
+// utime and stime are given in clock ticks
+func writeTestTask(t *testing.T, procDir string, pid int, ppid int, tid int, name string, utime int, stime int) {
+	stat := fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560 1000 0 0 0 %d %d 0 0 20 0 1 0 100 0 0",
+		tid, name, ppid, pid, pid, utime, stime)
+	dirs := []string{path.Join(procDir, fmt.Sprint(pid), "task", fmt.Sprint(tid))}
+	// the main thread also describes the process itself
+	if tid == pid {
+		dirs = append(dirs, path.Join(procDir, fmt.Sprint(pid)))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, "comm"), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadThreadCPUSeconds(t *testing.T) {
+	procDir := t.TempDir()
+	// plugin process 10 with a child process 20. process 30 is not part of the plugin
+	writeTestTask(t, procDir, 10, 1, 10, "python3", 100, 50)
+	writeTestTask(t, procDir, 10, 1, 11, "pt_thread", 200, 0)
+	writeTestTask(t, procDir, 10, 1, 12, "pt_thread", 200, 0)
+	writeTestTask(t, procDir, 20, 10, 20, "ffmpeg", 10, 0)
+	writeTestTask(t, procDir, 20, 10, 21, "decode thread", 30, 20)
+	writeTestTask(t, procDir, 30, 1, 30, "other process", 5000, 0)
+
+	pids, err := listProcessTree(procDir, 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pids, []int32{10, 20})
+
+	c := NewThreadPerformanceLogging(ControllerConfig{ProcfsRoot: procDir}, 10)
+	groups, err := c.ReadThreadCPUSeconds()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups, []threadGroup{
+		{Name: "pt_thread", Threads: 2, CPUSeconds: 4},
+		{Name: "python3", Threads: 1, CPUSeconds: 1.5},
+		{Name: "decode thread", Threads: 1, CPUSeconds: 0.5},
+		{Name: "ffmpeg", Threads: 1, CPUSeconds: 0.1},
+	})
+
+	c = NewThreadPerformanceLogging(ControllerConfig{ProcfsRoot: procDir, ThreadMetricsMaxNames: 2}, 10)
+	groups, err = c.ReadThreadCPUSeconds()
+	assert.NilError(t, err)
+	assert.Equal(t, len(groups), 3)
+	assert.Equal(t, groups[2].Name, otherThreadName)
+	assert.Equal(t, groups[2].Threads, 2)
+	assert.Equal(t, testutil.CollectAndCount(c), 6)
+}
+
+func TestReadThreadCPUSecondsCumulative(t *testing.T) {
+	procDir := t.TempDir()
+	writeTestTask(t, procDir, 10, 1, 10, "python3", 100, 0)
+	writeTestTask(t, procDir, 10, 1, 11, "pt_thread", 200, 0)
+	writeTestTask(t, procDir, 10, 1, 12, "pt_thread", 200, 0)
+	writeTestTask(t, procDir, 10, 1, 13, "ffmpeg", 50, 0)
+	c := NewThreadPerformanceLogging(ControllerConfig{ProcfsRoot: procDir, ThreadMetricsMaxNames: 2}, 10)
+	groups, err := c.ReadThreadCPUSeconds()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups, []threadGroup{
+		{Name: "pt_thread", Threads: 2, CPUSeconds: 4},
+		{Name: "python3", Threads: 1, CPUSeconds: 1},
+		{Name: otherThreadName, Threads: 1, CPUSeconds: 0.5},
+	})
+
+	// a pt_thread exits and ffmpeg gets busier than python3
+	assert.NilError(t, os.RemoveAll(path.Join(procDir, "10", "task", "12")))
+	writeTestTask(t, procDir, 10, 1, 11, "pt_thread", 300, 0)
+	writeTestTask(t, procDir, 10, 1, 13, "ffmpeg", 1000, 0)
+	groups, err = c.ReadThreadCPUSeconds()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups, []threadGroup{
+		{Name: otherThreadName, Threads: 1, CPUSeconds: 10},
+		{Name: "pt_thread", Threads: 1, CPUSeconds: 5},
+		{Name: "python3", Threads: 1, CPUSeconds: 1},
+	})
+
+	// a sample behind the last read adds nothing to the group
+	writeTestTask(t, procDir, 10, 1, 11, "pt_thread", 250, 0)
+	groups, err = c.ReadThreadCPUSeconds()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups[1], threadGroup{Name: "pt_thread", Threads: 1, CPUSeconds: 5})
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+const (
+	UploadBackendWaggle = "waggle"
+	UploadBackendLocal  = "local"
+)
+
+// Uploader makes a file available as a Waggle upload with its metadata. It returns
+// the name of the upload
+type Uploader interface {
+	Name() string
+	Upload(filePath string, timestamp int64, meta map[string]string) (string, error)
+}
+
+// NewUploader returns the uploader of the configured backend
+func NewUploader(c ControllerConfig) (Uploader, error) {
+	switch c.UploadBackend {
+	case UploadBackendWaggle, "":
+		return &WaggleUploader{Dir: c.UploadDir}, nil
+	case UploadBackendLocal:
+		return &LocalUploader{}, nil
+	default:
+		return nil, fmt.Errorf("unknown upload backend %q", c.UploadBackend)
+	}
+}
+
+// uploadMeta is the meta file of an upload as pywaggle writes it
+type uploadMeta struct {
+	Timestamp   int64             `json:"timestamp"`
+	Shape       []int             `json:"shape"`
+	ChecksumMD5 string            `json:"checksum-md5"`
+	Meta        map[string]string `json:"meta"`
+}
+
+// WaggleUploader puts files into the upload directory of the plugin, where the upload
+// agent of the node picks them up for Beehive. Like pywaggle, an upload is a directory
+// <timestamp>-<name> with the file as data and its metadata as meta in JSON
+type WaggleUploader struct {
+	Dir string
+}
+
+func (u *WaggleUploader) Name() string {
+	return UploadBackendWaggle
+}
+
+func (u *WaggleUploader) Upload(filePath string, timestamp int64, meta map[string]string) (string, error) {
+	name := fmt.Sprintf("%d-%s", timestamp, path.Base(filePath))
+	if err := os.MkdirAll(u.Dir, 0755); err != nil {
+		return "", err
+	}
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	// the upload is written under a temporary name and renamed into place,
+	// so the upload agent never sees a partial upload
+	tmpDir, err := os.MkdirTemp(u.Dir, ".upload-")
+	if err != nil {
+		return "", err
+	}
+	if err := writeUpload(tmpDir, src, timestamp, meta); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	if err := os.Rename(tmpDir, path.Join(u.Dir, name)); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return name, nil
+}
+
+// writeUpload writes the data and meta files of an upload into dir
+func writeUpload(dir string, src io.Reader, timestamp int64, meta map[string]string) error {
+	data, err := os.Create(path.Join(dir, "data"))
+	if err != nil {
+		return err
+	}
+	checksum := md5.New()
+	if _, err := io.Copy(io.MultiWriter(data, checksum), src); err != nil {
+		data.Close()
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	blob, err := json.Marshal(uploadMeta{
+		Timestamp:   timestamp,
+		Shape:       []int{},
+		ChecksumMD5: hex.EncodeToString(checksum.Sum(nil)),
+		Meta:        meta,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, "meta"), blob, 0644)
+}
+
+// LocalUploader is a stand-in of the upload agent for running outside of Waggle nodes.
+// It leaves the file where it is and only the upload message is published
+type LocalUploader struct{}
+
+func (u *LocalUploader) Name() string {
+	return UploadBackendLocal
+}
+
+func (u *LocalUploader) Upload(filePath string, timestamp int64, meta map[string]string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	return path.Base(filePath), nil
+}
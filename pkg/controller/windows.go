@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"github.com/waggle-sensor/edge-scheduler/pkg/interfacing"
+)
+
+var statWindows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+// windowedEventTypes are the performance samples kept in windows
+var windowedEventTypes = map[datatype.EventType]bool{
+	datatype.EventPluginPerfCPU: true,
+	datatype.EventPluginPerfMem: true,
+	datatype.EventPluginPerfGPU: true,
+}
+
+type WindowStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+type timedSample struct {
+	T time.Time
+	V float64
+}
+
+// maxWindowSamples caps the samples kept per metric at one per second over the longest
+// window, the shortest collection interval that can be set
+var maxWindowSamples = int(statWindows[len(statWindows)-1].Duration/time.Second) + 1
+
+// SampleWindows keeps performance samples of the last 15 minutes and reports their
+// min, max, mean and percentiles over 1, 5 and 15 minute windows. The samples kept
+// per metric are capped at maxWindowSamples, so memory use stays bounded whatever the
+// collection interval is changed to. When publishing is enabled, it notifies the
+// 1 minute window of each metric every minute
+type SampleWindows struct {
+	Notifier *interfacing.Notifier
+	mu       sync.Mutex
+	samples  map[string][]timedSample
+	quit     chan struct{}
+	log      *slog.Logger
+
+	promWindowStats *prometheus.Desc
+}
+
+func NewSampleWindows(c ControllerConfig) *SampleWindows {
+	return &SampleWindows{
+		Notifier: interfacing.NewNotifier(),
+		samples:  map[string][]timedSample{},
+		quit:     make(chan struct{}),
+		log:      componentLogger("windows"),
+
+		promWindowStats: prometheus.NewDesc(
+			"plugin_perf_window",
+			"Statistics of plugin performance samples over rolling windows",
+			[]string{"metric", "window", "stat"},
+			nil,
+		),
+	}
+}
+
+// metricName returns a short name of the performance event, e.g. "cpu" for sys.plugin.perf.cpu
+func metricName(eventType datatype.EventType) string {
+	s := string(eventType)
+	return s[strings.LastIndex(s, ".")+1:]
+}
+
+// Observe keeps the value of a performance sample
+func (w *SampleWindows) Observe(e datatype.Event) {
+	if !windowedEventTypes[e.Type] {
+		return
+	}
+	v, ok := eventValue(e)
+	if !ok {
+		return
+	}
+	name := metricName(e.Type)
+	t := time.Unix(0, e.Timestamp)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	samples := append(w.samples[name], timedSample{T: t, V: v})
+	// drop samples that are too old or over the cap
+	oldest := t.Add(-statWindows[len(statWindows)-1].Duration)
+	i := 0
+	for i < len(samples) && (samples[i].T.Before(oldest) || len(samples)-i > maxWindowSamples) {
+		i++
+	}
+	w.samples[name] = append(samples[:0], samples[i:]...)
+}
+
+func computeWindowStats(values []float64) WindowStats {
+	if len(values) == 0 {
+		return WindowStats{}
+	}
+	sort.Float64s(values)
+	sum := 0.
+	for _, v := range values {
+		sum += v
+	}
+	return WindowStats{
+		Count: len(values),
+		Min:   values[0],
+		Max:   values[len(values)-1],
+		Mean:  sum / float64(len(values)),
+		P50:   percentile(values, 50),
+		P95:   percentile(values, 95),
+		P99:   percentile(values, 99),
+	}
+}
+
+// Stats returns statistics of each metric over each window ending at the given time
+func (w *SampleWindows) Stats(now time.Time) map[string]map[string]WindowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := map[string]map[string]WindowStats{}
+	for name, samples := range w.samples {
+		out[name] = map[string]WindowStats{}
+		for _, window := range statWindows {
+			var values []float64
+			from := now.Add(-window.Duration)
+			for _, s := range samples {
+				if !s.T.Before(from) && !s.T.After(now) {
+					values = append(values, s.V)
+				}
+			}
+			out[name][window.Name] = computeWindowStats(values)
+		}
+	}
+	return out
+}
+
+func (w *SampleWindows) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.promWindowStats
+}
+
+func (w *SampleWindows) Collect(ch chan<- prometheus.Metric) {
+	for name, windows := range w.Stats(time.Now()) {
+		for window, s := range windows {
+			if s.Count == 0 {
+				continue
+			}
+			for stat, v := range map[string]float64{
+				"min":  s.Min,
+				"max":  s.Max,
+				"mean": s.Mean,
+				"p50":  s.P50,
+				"p95":  s.P95,
+				"p99":  s.P99,
+			} {
+				ch <- prometheus.MustNewConstMetric(w.promWindowStats, prometheus.GaugeValue, v, name, window, stat)
+			}
+		}
+	}
+}
+
+func (w *SampleWindows) Stop() {
+	w.quit <- struct{}{}
+}
+
+// Run notifies the 1 minute window of each metric every minute
+func (w *SampleWindows) Run() {
+	ticker := time.NewTicker(statWindows[0].Duration)
+	for {
+		select {
+		case t := <-ticker.C:
+			for name, windows := range w.Stats(t) {
+				s := windows[statWindows[0].Name]
+				if s.Count == 0 {
+					continue
+				}
+				blob, err := json.Marshal(s)
+				if err != nil {
+					w.log.Error("failed to encode window stats", "metric", name, "error", err)
+					continue
+				}
+				e := datatype.NewEventBuilder(datatype.EventType("sys.plugin.perf."+name+".window")).
+					AddValue(string(blob)).
+					AddEntry("window", statWindows[0].Name).
+					Build()
+				w.Notifier.Notify(e)
+			}
+		case <-w.quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
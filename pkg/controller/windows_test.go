@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/waggle-sensor/edge-scheduler/pkg/datatype"
+	"gotest.tools/v3/assert"
+)
+
+func TestSampleWindows(t *testing.T) {
+	w := NewSampleWindows(ControllerConfig{PerformanceCollectionInterval: 5})
+	now := time.Now()
+	// one CPU sample every 5 seconds for the last 20 minutes. The value is
+	// the number of minutes ago the sample was taken
+	for i := 240; i >= 0; i-- {
+		ago := time.Duration(i*5) * time.Second
+		w.Observe(newTestPerfEvent(datatype.EventPluginPerfCPU, now.Add(-ago), float64(int(ago.Minutes()))))
+	}
+	assert.Assert(t, len(w.samples["cpu"]) <= maxWindowSamples)
+
+	stats := w.Stats(now)
+	assert.Equal(t, stats["cpu"]["1m"].Count, 13)
+	assert.Equal(t, stats["cpu"]["1m"].Min, 0.)
+	assert.Equal(t, stats["cpu"]["1m"].Max, 1.)
+	assert.Equal(t, stats["cpu"]["5m"].Max, 5.)
+	assert.Equal(t, stats["cpu"]["15m"].Max, 15.)
+	assert.Equal(t, stats["cpu"]["15m"].P50, 7.)
+
+	// 6 stats for each of 3 windows
+	assert.Equal(t, testutil.CollectAndCount(w), 18)
+
+	// the windows stay whole when the collection interval is shortened
+	for i := 900; i >= 0; i-- {
+		w.Observe(newTestPerfEvent(datatype.EventPluginPerfMem, now.Add(-time.Duration(i)*time.Second), 1))
+	}
+	stats = w.Stats(now)
+	assert.Equal(t, stats["mem"]["15m"].Count, 901)
+	assert.Equal(t, len(w.samples["mem"]), maxWindowSamples)
+}